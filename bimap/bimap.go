@@ -0,0 +1,65 @@
+// Package bimap implements bi-directional maps.
+package bimap
+
+import "github.com/boutros/sopp/rdf"
+
+// URI2uint32 is a bi-directional map between URIs and uint32 IDs.
+// It is not safe for concurrent use.
+type URI2uint32 struct {
+	ids  map[rdf.URI]uint32
+	uris map[uint32]rdf.URI
+}
+
+// NewURI2uint32 returns a new, empty URI2uint32 map.
+func NewURI2uint32() *URI2uint32 {
+	return &URI2uint32{
+		ids:  make(map[rdf.URI]uint32),
+		uris: make(map[uint32]rdf.URI),
+	}
+}
+
+// Add stores the mapping between the URI and the ID. Any existing
+// mappings of either the URI or the ID are replaced.
+func (m *URI2uint32) Add(uri rdf.URI, id uint32) {
+	if old, ok := m.ids[uri]; ok {
+		delete(m.uris, old)
+	}
+	if old, ok := m.uris[id]; ok {
+		delete(m.ids, old)
+	}
+	m.ids[uri] = id
+	m.uris[id] = uri
+}
+
+// GetID returns the ID mapped to the URI, and true if it was found.
+func (m *URI2uint32) GetID(uri rdf.URI) (uint32, bool) {
+	id, ok := m.ids[uri]
+	return id, ok
+}
+
+// GetURI returns the URI mapped to the ID, and true if it was found.
+func (m *URI2uint32) GetURI(id uint32) (rdf.URI, bool) {
+	uri, ok := m.uris[id]
+	return uri, ok
+}
+
+// DeleteURI removes the URI and its ID from the map.
+func (m *URI2uint32) DeleteURI(uri rdf.URI) {
+	if id, ok := m.ids[uri]; ok {
+		delete(m.uris, id)
+		delete(m.ids, uri)
+	}
+}
+
+// DeleteID removes the ID and its URI from the map.
+func (m *URI2uint32) DeleteID(id uint32) {
+	if uri, ok := m.uris[id]; ok {
+		delete(m.ids, uri)
+		delete(m.uris, id)
+	}
+}
+
+// Size returns the number of mappings.
+func (m *URI2uint32) Size() int {
+	return len(m.ids)
+}
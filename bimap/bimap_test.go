@@ -0,0 +1,45 @@
+package bimap
+
+import (
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+func TestURI2uint32(t *testing.T) {
+	m := NewURI2uint32()
+	m.Add(rdf.URI("a"), 1)
+	m.Add(rdf.URI("b"), 2)
+
+	if id, ok := m.GetID(rdf.URI("a")); !ok || id != 1 {
+		t.Errorf("GetID(a) => %d, %v; want 1, true", id, ok)
+	}
+	if uri, ok := m.GetURI(2); !ok || uri != rdf.URI("b") {
+		t.Errorf("GetURI(2) => %q, %v; want \"b\", true", uri, ok)
+	}
+
+	// Remapping replaces the old mappings in both directions.
+	m.Add(rdf.URI("a"), 2)
+	if _, ok := m.GetURI(1); ok {
+		t.Error("GetURI(1) found after remapping a")
+	}
+	if _, ok := m.GetID(rdf.URI("b")); ok {
+		t.Error("GetID(b) found after remapping 2")
+	}
+	if m.Size() != 1 {
+		t.Errorf("Size() => %d; want 1", m.Size())
+	}
+
+	m.DeleteID(2)
+	if _, ok := m.GetID(rdf.URI("a")); ok {
+		t.Error("GetID(a) found after DeleteID(2)")
+	}
+	m.Add(rdf.URI("c"), 3)
+	m.DeleteURI(rdf.URI("c"))
+	if _, ok := m.GetURI(3); ok {
+		t.Error("GetURI(3) found after DeleteURI(c)")
+	}
+	if m.Size() != 0 {
+		t.Errorf("Size() => %d; want 0", m.Size())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boutros/sopp"
+	"github.com/boutros/sopp/rdf"
+)
+
+const (
+	importBatchSize = 1000
+
+	// How often to log the progress of an import
+	progressInterval = 10 * time.Second
+)
+
+// stdin is the name of the input read from standard input.
+const stdin = "-"
+
+// inputList is the list of inputs given with the -i flag.
+type inputList []string
+
+func (l *inputList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *inputList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// files returns the files to import, with the glob patterns expanded.
+func (l inputList) files() ([]string, error) {
+	var files []string
+	for _, input := range l {
+		if input == stdin {
+			files = append(files, stdin)
+			continue
+		}
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", input)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// importFile imports the file, or standard input if file is "-". Imports
+// of files are checkpointed, so that they can be resumed if interrupted.
+func importFile(db *sopp.DB, file string, opts sopp.ImportOptions) error {
+	var r io.Reader = os.Stdin
+	if file != stdin {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if opts.Source, err = sourceID(f); err != nil {
+			return err
+		}
+	}
+	if opts.Resume && opts.Source != "" {
+		if cp, err := db.Checkpoint(opts.Source); err == nil {
+			log.Printf("resuming import of %s at byte %d, after %d batches", file, cp.Offset, cp.Batch)
+		}
+	}
+
+	last := time.Now()
+	opts.Progress = func(p sopp.ImportProgress) {
+		if time.Since(last) >= progressInterval {
+			log.Printf("imported %d triples from %s (%d bytes read)", p.Triples, file, p.Bytes)
+			last = time.Now()
+		}
+	}
+	opts.OnError = func(err *rdf.DecodeError) {
+		log.Printf("%s:%v\n\t%s", file, err, err.Line)
+	}
+
+	rep, err := db.ImportWithOptions(r, opts)
+	log.Printf("imported from %s: %d triples read, %d stored, %d allready present, %d rejected",
+		file, rep.Read, rep.Stored, rep.Existing, rep.Rejected)
+	return err
+}
+
+// sourceID identifies the file by its path, size and modification time,
+// so that an interrupted import is only resumed if the file is unchanged.
+func sourceID(f *os.File) (string, error) {
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", path, fi.Size(), fi.ModTime().UnixNano()), nil
+}
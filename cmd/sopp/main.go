@@ -4,50 +4,88 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"runtime"
 
 	"github.com/boutros/sopp"
+	"github.com/boutros/sopp/rdf"
 )
 
-const importBatchSize = 1000
+// defaultBase is the base URI of new databases, unless given.
+const defaultBase = "http://localhost/"
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("sopp: ")
 
-	importF := flag.String("i", "", "import nt/ttl to db")
-	baseURI := flag.String("base", "http://localhost/", "base URI")
+	if len(os.Args) > 1 && os.Args[1] == "rebase" {
+		rebase(os.Args[2:])
+		return
+	}
+
+	var inputs inputList
+	flag.Var(&inputs, "i", "import nt/ttl, optionally gzip or bzip2 compressed, to db;\nmay be repeated, and be a glob pattern or - for standard input")
+	workers := flag.Int("w", 0, "number of workers resolving terms during import (one per CPU if 0)")
+	strict := flag.Bool("strict", false, "abort import on the first triple with errors")
+	resume := flag.Bool("resume", false, "resume import from where it was interrupted")
+	maxErrors := flag.Int("maxerr", 0, "abort import when more triples than this have errors (no limit if 0)")
+	graph := flag.String("g", "", "named graph to import into (default graph if empty)")
+	learnNS := flag.Bool("learnns", false, "store URIs compactly in the namespaces of the @prefix directives of imports")
+	baseURI := flag.String("base", "", "base URI of a new database (default "+defaultBase+");\nmust match the base URI of an existing database")
 	dump := flag.Bool("d", false, "dump database as turtle to standard out")
+	serve := flag.String("serve", "", "serve SPARQL endpoint at /sparql on given address, ex: :8080")
 
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: sopp <flags> <database file>")
+		fmt.Fprintln(os.Stderr, "       sopp rebase <database file> <new base URI>")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
-	if len(flag.Args()) < 1 || *baseURI == "" {
+	if len(flag.Args()) < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	db, err := sopp.Open(flag.Args()[0], *baseURI)
-	if err != nil {
+	file := flag.Args()[0]
+	if _, err := os.Stat(file); os.IsNotExist(err) && *baseURI == "" {
+		*baseURI = defaultBase
+	}
+	db, err := sopp.Open(file, *baseURI)
+	if _, ok := err.(*sopp.BaseError); ok {
+		log.Fatalf("%v; use sopp rebase to change it", err)
+	} else if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	if *importF != "" {
-		rdf, err := os.Open(*importF)
-		if err != nil {
-			log.Fatal(err)
+	if len(inputs) > 0 {
+		if *workers < 1 {
+			*workers = runtime.NumCPU()
 		}
+		opts := sopp.ImportOptions{
+			BatchSize: importBatchSize,
+			Workers:   *workers,
+			Strict:    *strict,
+			MaxErrors: *maxErrors,
+			Resume:    *resume,
 
-		n, err := db.Import(rdf, importBatchSize)
+			LearnNamespaces: *learnNS,
+		}
+		if *graph != "" {
+			opts.Graph = rdf.NewURI(*graph)
+		}
+		files, err := inputs.files()
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("imported %d triples from %s", n, *importF)
+		for _, file := range files {
+			if err := importFile(db, file, opts); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
 
 	if *dump {
@@ -55,4 +93,10 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+
+	if *serve != "" {
+		http.Handle("/sparql", server{db: db})
+		log.Printf("serving SPARQL endpoint at %s/sparql", *serve)
+		log.Fatal(http.ListenAndServe(*serve, nil))
+	}
 }
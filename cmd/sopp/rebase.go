@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/boutros/sopp"
+)
+
+// rebase changes the base URI of a database; see DB.ChangeBase.
+func rebase(args []string) {
+	fs := flag.NewFlagSet("rebase", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sopp rebase <database file> <new base URI>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	db, err := sopp.Open(fs.Arg(0), "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	st, err := db.Stats()
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := db.ChangeBase(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("changed base URI from %s to %s; %d terms re-encoded", st.Base, fs.Arg(1), n)
+}
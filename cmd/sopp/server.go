@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boutros/sopp"
+	"github.com/boutros/sopp/rdf"
+	"github.com/boutros/sopp/sparql"
+)
+
+// Media types for graph results (CONSTRUCT and DESCRIBE)
+const (
+	mediaTypeTurtle   = "text/turtle"
+	mediaTypeNTriples = "application/n-triples"
+)
+
+var (
+	// Offered media types, the first is the default.
+	solutionTypes = []string{sparql.MediaTypeJSON, sparql.MediaTypeXML, sparql.MediaTypeCSV, sparql.MediaTypeTSV}
+	graphTypes    = []string{mediaTypeTurtle, mediaTypeNTriples}
+)
+
+// server is a SPARQL 1.1 Protocol endpoint.
+type server struct {
+	db *sopp.DB
+}
+
+func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query string
+	switch r.Method {
+	case "GET":
+		query = r.URL.Query().Get("query")
+	case "POST":
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch ct {
+		case "application/x-www-form-urlencoded":
+			query = r.PostFormValue("query")
+		case "application/sparql-query":
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query = string(b)
+		default:
+			http.Error(w, "unsupported content type: "+ct, http.StatusUnsupportedMediaType)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if query == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+
+	q, err := sparql.Parse(query)
+	if err != nil {
+		http.Error(w, "malformed query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := s.db.Exec(q)
+	if err != nil {
+		log.Printf("query failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accept := r.Header.Get("Accept")
+	if res.Graph != nil {
+		mt := negotiate(accept, graphTypes)
+		w.Header().Set("Content-Type", mt+"; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		if mt == mediaTypeNTriples {
+			bw.WriteString(res.Graph.Serialize(rdf.NTriples, ""))
+		} else {
+			bw.WriteString(res.Graph.SerializeWithPrefixes("", rdf.NewPrefixMap()))
+		}
+		err = bw.Flush()
+	} else {
+		mt := negotiate(accept, solutionTypes)
+		w.Header().Set("Content-Type", mt+"; charset=utf-8")
+		switch mt {
+		case sparql.MediaTypeXML:
+			err = res.WriteXML(w)
+		case sparql.MediaTypeCSV:
+			err = res.WriteCSV(w)
+		case sparql.MediaTypeTSV:
+			err = res.WriteTSV(w)
+		default:
+			err = res.WriteJSON(w)
+		}
+	}
+	if err != nil {
+		log.Printf("writing response failed: %v", err)
+	}
+}
+
+// negotiate returns the offered media type best matching the Accept header.
+// If none of them are acceptable, the first offer is returned.
+func negotiate(accept string, offers []string) string {
+	var accepted byQuality
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if mt == "application/json" {
+			mt = sparql.MediaTypeJSON
+		}
+		accepted = append(accepted, candidate{mt, q})
+	}
+	sort.Stable(accepted)
+
+	for _, c := range accepted {
+		if c.q <= 0 {
+			break
+		}
+		for _, offer := range offers {
+			if c.mediaType == offer || c.mediaType == "*/*" ||
+				(strings.HasSuffix(c.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(c.mediaType, "*"))) {
+				return offer
+			}
+		}
+	}
+	return offers[0]
+}
+
+type candidate struct {
+	mediaType string
+	q         float64
+}
+
+// byQuality sorts accepted media types by descending quality.
+type byQuality []candidate
+
+func (b byQuality) Len() int           { return len(b) }
+func (b byQuality) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byQuality) Less(i, j int) bool { return b[i].q > b[j].q }
@@ -0,0 +1,148 @@
+package sopp
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+)
+
+// Literals of a registered datatype are stored as the ID of the datatype,
+// followed by the value:
+//
+//   0xFC | datatype ID (uvarint) | value
+//
+// The IDs are assigned when the datatype is registered, and persisted in the
+// datatypes bucket. The table of datatypes has the same shape as the table
+// of namespaces, and is likewise replaced when datatypes are registered.
+
+// datatypes returns the current datatype table.
+func (db *DB) datatypes() *nsTable {
+	db.muNS.RLock()
+	t := db.dts
+	db.muNS.RUnlock()
+	return t
+}
+
+// Datatypes returns the registered datatypes, in the order they were registered.
+func (db *DB) Datatypes() []rdf.URI {
+	t := db.datatypes()
+	ids := make([]int, 0, len(t.uris))
+	for id := range t.uris {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	res := make([]rdf.URI, len(ids))
+	for i, id := range ids {
+		res[i] = rdf.URI(t.uris[uint32(id)])
+	}
+	return res
+}
+
+// RegisterDatatypes assigns compact codes to the given datatypes, so that
+// their literals are stored with the code instead of the datatype URI.
+// Datatypes allready registered, and the built-in XSD datatypes which have
+// codes of their own, are ignored. The stored literals of the new datatypes
+// are re-encoded. Transactions started before the datatypes are committed
+// keep seeing the literals as they were encoded before.
+//
+// The datatypes registered with rdf.RegisterDatatype are registered when the
+// database is opened, and when an import starts.
+func (db *DB) RegisterDatatypes(dts ...rdf.URI) error {
+	db.muRegister.Lock()
+	defer db.muRegister.Unlock()
+
+	cur := db.datatypes()
+	var next *nsTable
+	var since int
+	err := db.kv.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucketDatatypes)
+		add := make(map[string]uint32)
+		for _, dt := range dts {
+			if _, ok := cur.ids[string(dt)]; ok || dt == "" || builtinDatatype(dt) {
+				continue
+			}
+			if _, ok := add[string(dt)]; ok {
+				continue
+			}
+			n, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put(u32tob(uint32(n)), []byte(dt)); err != nil {
+				return err
+			}
+			add[string(dt)] = uint32(n)
+		}
+		if len(add) == 0 {
+			return nil
+		}
+
+		next = cur.with(add)
+		if _, err := reencodeTerms(tx, func(v []byte) ([]byte, error) {
+			if v[0] != 0xFF {
+				return v, nil
+			}
+			dt, val, ok := decodeTagged(v)
+			if !ok {
+				return nil, fmt.Errorf("cannot decode as literal: %v", v)
+			}
+			if _, ok := add[dt]; !ok {
+				return v, nil
+			}
+			return encodeLiteral(next, rdf.NewTypedLiteral(val, rdf.URI(dt))), nil
+		}); err != nil {
+			return err
+		}
+		// The table is replaced before the transaction commits, so that
+		// no writer after it encodes literals with the old table.
+		since = tx.ID()
+		db.setDatatypes(next, since)
+		return nil
+	})
+	if err != nil && next != nil {
+		db.setDatatypes(cur, since)
+	}
+	return err
+}
+
+// setDatatypes replaces the datatype table, as of the transaction with the
+// given ID; see encodingAt. Encoded terms cached by imports are stale when
+// the encoding of literals change.
+func (db *DB) setDatatypes(t *nsTable, since int) {
+	db.muNS.Lock()
+	db.dts = t
+	db.changeEncoding(since)
+	db.muNS.Unlock()
+	atomic.AddUint64(&db.removals, 1)
+}
+
+// learnDatatypes registers the datatypes registered with rdf.RegisterDatatype,
+// if any of them are not allready registered.
+func (db *DB) learnDatatypes() error {
+	t := db.datatypes()
+	for _, dt := range rdf.Datatypes() {
+		if _, ok := t.ids[string(dt)]; !ok && !builtinDatatype(dt) {
+			return db.RegisterDatatypes(rdf.Datatypes()...)
+		}
+	}
+	return nil
+}
+
+// loadDatatypes loads the datatype table from the database.
+func (db *DB) loadDatatypes(tx *bolt.Tx) error {
+	t := readTable(tx.Bucket(bucketDatatypes))
+	db.muNS.Lock()
+	db.dts = t
+	db.muNS.Unlock()
+	return nil
+}
+
+// builtinDatatype reports whether literals of the datatype are stored with
+// a code of their own, regardless of the registered datatypes.
+func builtinDatatype(dt rdf.URI) bool {
+	b := encodeLiteral(newNSTable(), rdf.NewTypedLiteral("", dt))
+	return b[0] != 0xFF
+}
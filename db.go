@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/bimap"
 	"github.com/boutros/sopp/rdf"
 )
 
@@ -22,9 +27,13 @@ var (
 	ErrNotFound = errors.New("not found")
 
 	// ErrDBFull is returned when the database cannot store more terms.
-	// Note: this will most likely be lower than MaxTerms, since the ID's of
-	// deleted terms are not reclaimed.
+	// The IDs of deleted terms are reclaimed, so this happens only when
+	// MaxTerms terms are stored.
 	ErrDBFull = errors.New("database full: term limit reached")
+
+	// ErrTooManyErrors is returned when an import is aborted because
+	// more triples than allowed by ImportOptions.MaxErrors were rejected.
+	ErrTooManyErrors = errors.New("import aborted: too many errors")
 )
 
 const (
@@ -35,17 +44,104 @@ const (
 // Buckets in the key-value store:
 var (
 	// RDF Terms
-	bucketTerms    = []byte("terms")  // uint32 -> term
-	bucketIdxTerms = []byte("iterms") // term -> uint32
+	bucketTerms      = []byte("terms")      // uint32 -> term
+	bucketIdxTerms   = []byte("iterms")     // term -> uint32
+	bucketFreeIDs    = []byte("freeids")    // "ids" -> bitmap of IDs of deleted terms, to be reused
+	bucketNamespaces = []byte("namespaces") // uint32 -> namespace, see namespace.go
+	bucketDatatypes  = []byte("datatypes")  // uint32 -> datatype, see datatype.go
 
 	// Triple indices       composite key         bitmap
 	bucketSPO = []byte("spo") // Subect + Predicate -> Object
 	bucketOSP = []byte("osp") // Object + Subject   -> Predicate
 	bucketPOS = []byte("pos") // Predicate + Object -> Subject
+
+	// Named graphs
+	bucketGraphs = []byte("graphs") // uint32 -> bucket with spo, osp & pos indices of graph
+
+	// Imports; the sequence is used to scope blank node labels
+	bucketImports = []byte("imports")
+	bucketCheckpoints = []byte("checkpoints") // source -> checkpoint of import
+
+	// Metadata
+	bucketMeta = []byte("meta") // key -> value
+)
+
+// BaseError is returned by Open when the given base URI does not match the
+// base URI of the database.
+type BaseError struct {
+	Base   string // base URI given
+	Stored string // base URI of the database
+}
+
+func (e *BaseError) Error() string {
+	return fmt.Sprintf("base URI %q does not match the base URI of the database: %q", e.Base, e.Stored)
+}
+
+// keyFreeIDs is the key of the free-list bitmap in the freeids bucket.
+var keyFreeIDs = []byte("ids")
+
+// Keys in the metadata bucket:
+var (
+	metaVersion = []byte("version") // format version, stored as uint64
+	metaBase    = []byte("base")    // base URI
+	metaCreated = []byte("created") // creation time in Unix nanoseconds, stored as uint64
+
+	// Counters, stored as uint64
+	metaNumTriples = []byte("triples") // number of triples in all graphs
+	// The number of keys and the size of the bitmaps in each index,
+	// summed over all graphs, are stored under "<index>.keys" and "<index>.bytes".
 )
 
+// formatVersion is the version of the storage format, which is increased
+// with every change of the format; see migrations.
+var formatVersion = uint64(len(migrations))
+
+// migrations holds the changes of the storage format. Databases of an older
+// version are migrated when opened, by running migrations[version:] in order.
+var migrations = []struct {
+	desc    string
+	migrate func(db *DB, tx *bolt.Tx) error
+}{
+	// 1
+	{"count triples, index keys and bitmap sizes", func(_ *DB, tx *bolt.Tx) error {
+		return recount(tx)
+	}},
+	// 2
+	{"encode URIs in registered namespaces", func(_ *DB, _ *bolt.Tx) error {
+		// No namespaces are registered yet, so no terms change
+		return nil
+	}},
+	// 3
+	{"encode length of language tags and datatypes as uvarint", migrateTaggedLiterals},
+	// 4
+	{"encode more XSD datatypes with a single byte", func(db *DB, tx *bolt.Tx) error {
+		_, err := reencodeTerms(tx, func(v []byte) ([]byte, error) {
+			if v[0] != 0xFF {
+				return v, nil
+			}
+			term, err := db.decode(tx, v)
+			if err != nil {
+				return nil, err
+			}
+			return db.encode(tx, term), nil
+		})
+		return err
+	}},
+	// 5
+	{"encode literals of registered datatypes with the ID of the datatype", func(_ *DB, _ *bolt.Tx) error {
+		// No datatypes are registered yet, so no terms change
+		return nil
+	}},
+}
+
 // DB is a RDF triple store backed by a key-value store.
 type DB struct {
+	// removals is incremented each time a term is removed or renamed, so that
+	// term caches held outside of a transaction know when they are stale.
+	// It is accessed atomically, and must be the first field to be
+	// 64-bit aligned on 32-bit platforms.
+	removals uint64
+
 	// kv is the key-value database (BoltDB) backing the triple store
 	kv *bolt.DB
 
@@ -56,26 +152,64 @@ type DB struct {
 	//
 	// The base should include the scheme and hostname, ex: http://example.org/
 	//
-	// It must not be changed as long as the database is open, but may
-	// be set in the call to Open() when opening a database.
+	// It is stored when the database is created, and is only changed
+	// by ChangeBase.
 	base string
 
-	// TODOs >>
-
 	// muPred protects the bimap of predicates
-	//muPred sync.RWMutex
+	muPred sync.RWMutex
 
 	// The number of predicates used in a RDF is usually quite low, so we
 	// maintain a cache of those in a bi-directional map
-	//pred bimap.URI2uint32
+	pred *bimap.URI2uint32
+
+	// predSince is the ID of the last transaction which gave a term ID to
+	// another term, by reusing the ID of a deleted term or by renaming a
+	// term. Transactions on older snapshots may know the ID as another term
+	// than the cache does, so they look up predicates in their snapshot.
+	// It is protected by muPred.
+	predSince int
+
+	// muNS protects the base URI and the namespace and datatype tables,
+	// which are replaced when the base is changed, or namespaces or datatypes
+	// are registered. muRegister serializes those changes.
+	muNS       sync.RWMutex
+	muRegister sync.Mutex
+
+	// URIs in other namespaces than the base are compressed using
+	// a table of namespaces, persisted in the namespaces bucket.
+	ns *nsTable
+
+	// Literals of registered datatypes are stored with the ID of the
+	// datatype, from a table persisted in the datatypes bucket.
+	dts *nsTable
+
+	// encSince is the ID of the last transaction which changed the base URI,
+	// or the namespace or datatype table. Transactions on older snapshots
+	// encode and decode terms with the ones stored in their snapshot, which
+	// are kept in past by transaction ID. Both are protected by muNS.
+	encSince int
+	past     map[int]encoding
 }
 
 // Stats holds some statistics of the triple store.
 type Stats struct {
-	NumTerms int
-	//NumTriples    int
+	NumTerms    int
+	NumTriples  int
 	File        string
 	SizeInBytes int
+	Version     int       // storage format version
+	Base        string    // base URI
+	Created     time.Time // zero if created by a version not storing it
+
+	// Statistics of the triple indices, summed over all graphs
+	SPO, OSP, POS IndexStats
+}
+
+// IndexStats holds statistics of a triple index.
+type IndexStats struct {
+	NumKeys     int // number of composite keys
+	BitmapBytes int // total size of the stored bitmaps
 }
 
 // Stats return statistics about the triple store.
@@ -84,7 +218,24 @@ func (db *DB) Stats() (Stats, error) {
 	if err := db.kv.View(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(bucketTerms)
 		st.NumTerms = bkt.Stats().KeyN
-		//st.NumTriples = int(atomic.LoadInt64(&db.numTr))
+		st.NumTriples = getCount(tx, metaNumTriples)
+		st.Version = getCount(tx, metaVersion)
+		st.Base = db.encodingAt(tx).base
+		if created := getCount(tx, metaCreated); created != 0 {
+			st.Created = time.Unix(0, int64(created))
+		}
+		for _, idx := range []struct {
+			name []byte
+			st   *IndexStats
+		}{
+			{bucketSPO, &st.SPO},
+			{bucketOSP, &st.OSP},
+			{bucketPOS, &st.POS},
+		} {
+			keys, bytes := indexCountKeys(idx.name)
+			idx.st.NumKeys = getCount(tx, keys)
+			idx.st.BitmapBytes = getCount(tx, bytes)
+		}
 		st.File = db.kv.Path()
 		s, err := os.Stat(st.File)
 		if err != nil {
@@ -99,7 +250,10 @@ func (db *DB) Stats() (Stats, error) {
 }
 
 // Open creates and opens a database at the given path.
-// If the file does not exist it will be created.
+// If the file does not exist it will be created, with the given base URI.
+// An existing database must be opened with the base URI it was created
+// with, or else a *BaseError is returned; if base is empty, the stored
+// base URI is used. Databases of an older format version are migrated.
 // Only one process can have access to the file at a time.
 func Open(path string, base string) (*DB, error) {
 	kv, err := bolt.Open(path, 0666, nil)
@@ -109,8 +263,20 @@ func Open(path string, base string) (*DB, error) {
 	db := &DB{
 		kv:   kv,
 		base: base,
+		pred: bimap.NewURI2uint32(),
+		ns:   newNSTable(),
+		dts:  newNSTable(),
+		past: make(map[int]encoding),
+	}
+	if err := db.setup(); err != nil {
+		kv.Close()
+		return nil, err
+	}
+	if err := db.learnDatatypes(); err != nil {
+		kv.Close()
+		return nil, err
 	}
-	return db.setup()
+	return db, nil
 }
 
 // Close closes the database, relasing the lock on the database file.
@@ -118,322 +284,1134 @@ func (db *DB) Close() error {
 	return db.kv.Close()
 }
 
-// setup makes sure the database has all the required buckets.
-func (db *DB) setup() (*DB, error) {
-	err := db.kv.Update(func(tx *bolt.Tx) error {
+// setup makes sure the database has all the required buckets, checks the
+// base URI and migrates the database to the current format version.
+func (db *DB) setup() error {
+	return db.kv.Update(func(tx *bolt.Tx) error {
 		// Make sure all the required buckets are present
-		for _, b := range [][]byte{bucketTerms, bucketIdxTerms, bucketSPO, bucketPOS, bucketOSP} {
+		for _, b := range [][]byte{bucketTerms, bucketIdxTerms, bucketSPO, bucketPOS, bucketOSP, bucketGraphs, bucketImports, bucketCheckpoints, bucketMeta, bucketFreeIDs, bucketNamespaces, bucketDatatypes} {
 			_, err := tx.CreateBucketIfNotExists(b)
 			if err != nil {
 				return err
 			}
 		}
 
-		/*
-			// Count number of triples
-			bkt = tx.Bucket(bSPO)
-			cur = bkt.Cursor()
-
-			var n uint64
-			for k, v := cur.First(); k != nil; k, v = cur.Next() {
-				if v != nil {
-					bitmap := roaring.NewBitmap()
-					_, err := bitmap.ReadFrom(bytes.NewReader(v))
-					if err != nil {
-						return err
-					}
-					n += bitmap.GetCardinality()
-				} // else ?
-			}
-			db.numTr = int64(n)
-		*/
+		if err := db.loadNamespaces(tx); err != nil {
+			return err
+		}
+		if err := db.loadDatatypes(tx); err != nil {
+			return err
+		}
+		if err := db.setupMeta(tx); err != nil {
+			return err
+		}
 
-		return nil
+		// Load the predicate cache
+		return db.loadPredicates(tx)
 	})
-	return db, err
 }
 
-// Insert stores the given Triple.
-func (db *DB) Insert(tr rdf.Triple) error {
-	err := db.kv.Update(func(tx *bolt.Tx) error {
-		sID, err := db.addTerm(tx, tr.Subj)
-		if err != nil {
+// setupMeta stores the metadata of a new database. For an existing database
+// it checks the base URI and runs the migrations needed, if any.
+func (db *DB) setupMeta(tx *bolt.Tx) error {
+	meta := tx.Bucket(bucketMeta)
+	stored := meta.Get(metaBase)
+	version := uint64(getCount(tx, metaVersion))
+	if meta.Get(metaVersion) == nil && tx.Bucket(bucketTerms).Stats().KeyN == 0 {
+		// A new database, or one created before the format was versioned
+		// without any terms; nothing to migrate.
+		version = formatVersion
+		if err := putUint64(meta, metaCreated, uint64(time.Now().UnixNano())); err != nil {
 			return err
 		}
+	}
 
-		// TODO get pID from cache
-		pID, err := db.addTerm(tx, tr.Pred)
-		if err != nil {
+	switch {
+	case stored != nil && db.base == "":
+		db.base = string(stored)
+	case stored != nil && db.base != string(stored):
+		return &BaseError{Base: db.base, Stored: string(stored)}
+	case db.base == "":
+		return errors.New("base URI required to create database")
+	case stored == nil:
+		// The base URI was not stored before the format was versioned.
+		if err := meta.Put(metaBase, []byte(db.base)); err != nil {
 			return err
 		}
+	}
 
-		oID, err := db.addTerm(tx, tr.Obj)
-		if err != nil {
-			return err
+	if version > formatVersion {
+		return fmt.Errorf("database format version %d not supported; newest is %d", version, formatVersion)
+	}
+	for _, m := range migrations[version:] {
+		if err := m.migrate(db, tx); err != nil {
+			return fmt.Errorf("migrating database to version %d: %s: %v", version+1, m.desc, err)
 		}
+		version++
+	}
+	return putUint64(meta, metaVersion, version)
+}
 
-		return db.storeTriple(tx, sID, pID, oID)
+// Insert stores the given Triple in the default graph.
+func (db *DB) Insert(tr rdf.Triple) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Insert(tr)
 	})
-	return err
 }
 
-// Delete removes the given Triple from the indices. It also removes
-// any Term unique to that Triple from the store.
+// InsertIn stores the given Triple in the named graph g. The graph
+// is created if it does not exist.
+func (db *DB) InsertIn(g rdf.URI, tr rdf.Triple) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.InsertIn(g, tr)
+	})
+}
+
+// insert stores the triple in the graph with the given ID, where 0 is the
+// default graph. Named graphs must allready exist.
+func (db *DB) insert(tx *bolt.Tx, g uint32, tr rdf.Triple) error {
+	sID, err := db.addTerm(tx, tr.Subj)
+	if err != nil {
+		return err
+	}
+
+	pID, err := db.addPred(tx, tr.Pred)
+	if err != nil {
+		return err
+	}
+
+	oID, err := db.addTerm(tx, tr.Obj)
+	if err != nil {
+		return err
+	}
+
+	return db.storeTriple(tx, g, sID, pID, oID)
+}
+
+// Delete removes the given Triple from the indices of the default graph.
+// It also removes any Term unique to that Triple from the store.
 // It return ErrNotFound if the Triple is not stored
 func (db *DB) Delete(tr rdf.Triple) error {
-	err := db.kv.Update(func(tx *bolt.Tx) error {
-		sID, err := db.getID(tx, tr.Subj)
-		if err != nil {
-			return err
-		}
+	return db.Update(func(tx *Tx) error {
+		return tx.Delete(tr)
+	})
+}
 
-		// TODO get pID from cache
-		pID, err := db.getID(tx, tr.Pred)
-		if err != nil {
-			return err
+// DeleteFrom removes the given Triple from the named graph g. The graph
+// itself is kept, even if it becomes empty; see DropGraph.
+// It return ErrNotFound if the Triple is not stored in the graph.
+func (db *DB) DeleteFrom(g rdf.URI, tr rdf.Triple) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteFrom(g, tr)
+	})
+}
+
+func (db *DB) delete(tx *bolt.Tx, g uint32, tr rdf.Triple) error {
+	sID, err := db.getID(tx, tr.Subj)
+	if err != nil {
+		return err
+	}
+
+	pID, err := db.getPredID(tx, tr.Pred)
+	if err != nil {
+		return err
+	}
+
+	oID, err := db.getID(tx, tr.Obj)
+	if err != nil {
+		return err
+	}
+
+	return db.removeTriple(tx, g, sID, pID, oID)
+}
+
+// Has checks if the given Triple is stored in the default graph.
+func (db *DB) Has(tr rdf.Triple) (exists bool, err error) {
+	err = db.View(func(tx *Tx) error {
+		exists, err = tx.Has(tr)
+		return err
+	})
+	return exists, err
+}
+
+// HasIn checks if the given Triple is stored in the named graph g.
+func (db *DB) HasIn(g rdf.URI, tr rdf.Triple) (exists bool, err error) {
+	err = db.View(func(tx *Tx) error {
+		exists, err = tx.HasIn(g, tr)
+		return err
+	})
+	return exists, err
+}
+
+func (db *DB) has(tx *bolt.Tx, g uint32, tr rdf.Triple) (bool, error) {
+	pID, err := db.getPredID(tx, tr.Pred)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	sID, err := db.getID(tx, tr.Subj)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	oID, err := db.getID(tx, tr.Obj)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	bkt := graphIndex(tx, g, bucketSPO)
+	if bkt == nil {
+		return false, nil
+	}
+
+	sp := make([]byte, 8)
+	copy(sp, u32tob(sID))
+	copy(sp[4:], u32tob(pID))
+
+	bitmap := roaring.NewBitmap()
+	bo := bkt.Get(sp)
+	if bo == nil {
+		return false, nil
+	}
+
+	_, err = bitmap.ReadFrom(bytes.NewReader(bo))
+	if err != nil {
+		return false, err
+	}
+
+	return bitmap.Contains(oID), nil
+}
+
+// Describe returns a graph with all the triples in the default graph where
+// the given node is subject. If asObject is true, it also includes the triples
+// where the node is object.
+func (db *DB) Describe(node rdf.Subject, asObject bool) (g *rdf.Graph, err error) {
+	err = db.View(func(tx *Tx) error {
+		g, err = tx.Describe(node, asObject)
+		return err
+	})
+	return g, err
+}
+
+// DescribeIn is like Describe, but only considers the triples stored
+// in the named graph name.
+func (db *DB) DescribeIn(name rdf.URI, node rdf.Subject, asObject bool) (g *rdf.Graph, err error) {
+	err = db.View(func(tx *Tx) error {
+		g, err = tx.DescribeIn(name, node, asObject)
+		return err
+	})
+	return g, err
+}
+
+// describe inserts the triples describing node in the graph with ID gID
+// into the given graph.
+func (db *DB) describe(tx *bolt.Tx, gID uint32, node rdf.Subject, asObject bool, g *rdf.Graph) error {
+	spo := graphIndex(tx, gID, bucketSPO)
+	if spo == nil {
+		return nil
+	}
+	bkt := tx.Bucket(bucketIdxTerms)
+	bt := db.encode(tx, node)
+	bs := bkt.Get(bt)
+	if bs == nil {
+		return nil
+	}
+	// seek in SPO index:
+	// WHERE { <node> ?p ?o }
+	sid := btou32(bs)
+	cur := spo.Cursor()
+outerSPO:
+	for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
+		switch bytes.Compare(k[:4], bs) {
+		case 0:
+			bkt = tx.Bucket(bucketTerms)
+			pred, err := db.getPred(tx, btou32(k[4:]))
+			if err == ErrNotFound {
+				return errors.New("bug: term ID in index, but not stored")
+			} else if err != nil {
+				return err
+			}
+			bitmap := roaring.NewBitmap()
+			_, err = bitmap.ReadFrom(bytes.NewReader(v))
+			if err != nil {
+				return err
+			}
+			it := bitmap.Iterator()
+			for it.HasNext() {
+				o := it.Next()
+				b := bkt.Get(u32tob(o))
+				if b == nil {
+					return errors.New("bug: term ID in index, but not stored")
+				}
+
+				obj, err := db.decode(tx, b)
+				if err != nil {
+					return err
+				}
+				g.Insert(rdf.Triple{Subj: node, Pred: pred, Obj: obj})
+			}
+		case 1:
+			break outerSPO
 		}
+	}
 
-		oID, err := db.getID(tx, tr.Obj)
-		if err != nil {
-			return err
+	if !asObject {
+		return nil
+	}
+	// seek in OSP index:
+	// WHERE { ?s ?p <node> }
+	cur = graphIndex(tx, gID, bucketOSP).Cursor()
+outerOSP:
+	for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
+		switch bytes.Compare(k[:4], bs) {
+		case 0:
+			bkt = tx.Bucket(bucketTerms)
+			b := bkt.Get(k[4:])
+			if b == nil {
+				return errors.New("bug: term ID in index, but not stored")
+			}
+			subj, err := db.decode(tx, b)
+			if err != nil {
+				return err
+			}
+			bitmap := roaring.NewBitmap()
+			_, err = bitmap.ReadFrom(bytes.NewReader(v))
+			if err != nil {
+				return err
+			}
+			it := bitmap.Iterator()
+			for it.HasNext() {
+				pred, err := db.getPred(tx, it.Next())
+				if err == ErrNotFound {
+					return errors.New("bug: term ID in index, but not stored")
+				} else if err != nil {
+					return err
+				}
+				g.Insert(rdf.Triple{Subj: subj.(rdf.Subject), Pred: pred, Obj: node})
+			}
+		case 1:
+			break outerOSP
 		}
+	}
+
+	return nil
+}
 
-		return db.removeTriple(tx, sID, pID, oID)
+// Construct returns a graph with all the stored triples matching the given
+// pattern. Any position of the pattern which is not an RDF Term (ex: rdf.Any)
+// matches every term.
+func (db *DB) Construct(p rdf.Pattern) (g *rdf.Graph, err error) {
+	err = db.View(func(tx *Tx) error {
+		g, err = tx.Construct(p)
+		return err
 	})
-	return err
+	return g, err
 }
 
-// Has checks if the given Triple is stored.
-func (db *DB) Has(tr rdf.Triple) (exists bool, err error) {
-	err = db.kv.View(func(tx *bolt.Tx) error {
-		sID, err := db.getID(tx, tr.Subj)
+// Match calls fn for every stored triple matching the given pattern. If fn
+// returns an error, the iteration stops and the error is returned.
+func (db *DB) Match(p rdf.Pattern, fn func(rdf.Triple) error) error {
+	return db.View(func(tx *Tx) error {
+		return tx.Match(p, fn)
+	})
+}
+
+// DeleteMatching removes all the triples in the default graph matching the
+// given pattern in a single transaction, and returns the number of triples
+// removed. Terms which are no longer part of any triple are removed from the store.
+func (db *DB) DeleteMatching(p rdf.Pattern) (n int, err error) {
+	err = db.Update(func(tx *Tx) error {
+		n, err = tx.DeleteMatching(p)
+		return err
+	})
+	return n, err
+}
+
+// DeleteResource removes all the triples in the default graph where the given
+// URI is subject in a single transaction, and returns the number of triples
+// removed. If includeIncoming is true, the triples where it is object are
+// removed as well.
+func (db *DB) DeleteResource(uri rdf.URI, includeIncoming bool) (n int, err error) {
+	err = db.Update(func(tx *Tx) error {
+		n, err = tx.DeleteResource(uri, includeIncoming)
+		return err
+	})
+	return n, err
+}
+
+// Diff holds the changes made by Replace.
+type Diff struct {
+	Removed *rdf.Graph // triples removed
+	Added   *rdf.Graph // triples inserted
+}
+
+// Replace replaces the description of node in the default graph with the
+// triples of g in a single transaction, and returns the triples removed and
+// added. All the triples of g must have node as subject.
+func (db *DB) Replace(node rdf.URI, g *rdf.Graph) (diff Diff, err error) {
+	err = db.Update(func(tx *Tx) error {
+		diff, err = tx.Replace(node, g)
+		return err
+	})
+	return diff, err
+}
+
+// RenameURI changes the URI old to new in all the triples where it occurs,
+// in all graphs, including the name of a named graph. If new is allready
+// stored, the two are merged as by MergeURIs(new, old).
+// It returns ErrNotFound if old is not stored.
+func (db *DB) RenameURI(old, new rdf.URI) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.RenameURI(old, new)
+	})
+}
+
+// MergeURIs replaces the URI drop with keep in all the triples where it
+// occurs, in all graphs, and removes drop from the store. Triples which
+// become equal are stored once. If drop names a graph, its triples are
+// moved to the graph named keep.
+// It returns ErrNotFound if any of the URIs are not stored.
+func (db *DB) MergeURIs(keep, drop rdf.URI) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.MergeURIs(keep, drop)
+	})
+}
+
+// patternIDs returns the IDs of the terms in the pattern, with 0 in the
+// positions which are not bound to a term. It returns false if any of the
+// terms are not stored, in which case no triples can match the pattern.
+func (db *DB) patternIDs(tx *bolt.Tx, p rdf.Pattern) (ids [3]uint32, ok bool, err error) {
+	for i, q := range []rdf.QVar{p.Subj, p.Pred, p.Obj} {
+		term, isTerm := q.(rdf.Term)
+		if !isTerm {
+			continue
+		}
+		id, err := db.getID(tx, term)
 		if err == ErrNotFound {
-			return nil
+			return ids, false, nil
 		} else if err != nil {
+			return ids, false, err
+		}
+		ids[i] = id
+	}
+	return ids, true, nil
+}
+
+// matchIDs calls fn with the term IDs of every triple matching the given IDs,
+// where the ID 0 matches any term. The index used is chosen based on which
+// positions are bound:
+//
+//   bound     | index | lookup
+//   ----------|-------|---------------------
+//   S, P, (O) | SPO   | key S+P
+//   S, O      | OSP   | key O+S
+//   P, O      | POS   | key P+O
+//   S         | SPO   | prefix scan on S
+//   O         | OSP   | prefix scan on O
+//   P         | POS   | prefix scan on P
+//   none      | SPO   | full scan
+func (db *DB) matchIDs(tx *bolt.Tx, s, p, o uint32, fn func(s, p, o uint32) error) error {
+	switch {
+	case s != 0 && p != 0:
+		return scanIndex(tx.Bucket(bucketSPO), compositeKey(s, p), func(k1, k2, v uint32) error {
+			if o != 0 && v != o {
+				return nil
+			}
+			return fn(k1, k2, v)
+		})
+	case s != 0 && o != 0:
+		return scanIndex(tx.Bucket(bucketOSP), compositeKey(o, s), func(k1, k2, v uint32) error {
+			return fn(k2, v, k1)
+		})
+	case p != 0 && o != 0:
+		return scanIndex(tx.Bucket(bucketPOS), compositeKey(p, o), func(k1, k2, v uint32) error {
+			return fn(v, k1, k2)
+		})
+	case s != 0:
+		return scanIndex(tx.Bucket(bucketSPO), u32tob(s), fn)
+	case o != 0:
+		return scanIndex(tx.Bucket(bucketOSP), u32tob(o), func(k1, k2, v uint32) error {
+			return fn(k2, v, k1)
+		})
+	case p != 0:
+		return scanIndex(tx.Bucket(bucketPOS), u32tob(p), func(k1, k2, v uint32) error {
+			return fn(v, k1, k2)
+		})
+	default:
+		return scanIndex(tx.Bucket(bucketSPO), nil, fn)
+	}
+}
+
+// scanIndex iterates over all keys in the given index bucket starting with
+// prefix, and calls fn with both parts of the composite key, and every ID in
+// the corresponding bitmap.
+func scanIndex(bkt *bolt.Bucket, prefix []byte, fn func(k1, k2, v uint32) error) error {
+	cur := bkt.Cursor()
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		if len(k) != 8 {
+			panic("len(index key) != 8")
+		}
+		k1, k2 := btou32(k[:4]), btou32(k[4:])
+
+		bitmap := roaring.NewBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
 			return err
 		}
-		// TODO get pID from cache, and move to top before sID
-		pID, err := db.getID(tx, tr.Pred)
-		if err == ErrNotFound {
+		it := bitmap.Iterator()
+		for it.HasNext() {
+			if err := fn(k1, k2, it.Next()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compositeKey returns the 8-byte index key of the two given IDs.
+func compositeKey(a, b uint32) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint32(key, a)
+	binary.BigEndian.PutUint32(key[4:], b)
+	return key
+}
+
+// getBitmap returns the bitmap stored under the composite key of the given
+// IDs in an index. If the key is not present, an empty bitmap is returned.
+func getBitmap(tx *bolt.Tx, idx []byte, k1, k2 uint32) (*roaring.Bitmap, error) {
+	bitmap := roaring.NewBitmap()
+	bo := tx.Bucket(idx).Get(compositeKey(k1, k2))
+	if bo == nil {
+		return bitmap, nil
+	}
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return nil, err
+	}
+	return bitmap, nil
+}
+
+// Graphs returns the names of all the named graphs, in the order they were
+// created. The default graph is not included.
+func (db *DB) Graphs() ([]rdf.URI, error) {
+	var res []rdf.URI
+	err := db.kv.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGraphs).ForEach(func(k, _ []byte) error {
+			term, err := db.getTerm(tx, btou32(k))
+			if err != nil {
+				return err
+			}
+			res = append(res, term.(rdf.URI))
 			return nil
-		} else if err != nil {
+		})
+	})
+	return res, err
+}
+
+// DropGraph removes the named graph and all its triples. Terms which are
+// not used in any other graph are removed from the store.
+// It returns ErrNotFound if there is no graph with the given name.
+func (db *DB) DropGraph(name rdf.URI) error {
+	return db.kv.Update(func(tx *bolt.Tx) error {
+		gID, err := db.getID(tx, name)
+		if err != nil {
 			return err
 		}
-		oID, err := db.getID(tx, tr.Obj)
-		if err == ErrNotFound {
+		graphs := tx.Bucket(bucketGraphs)
+		if graphs.Bucket(u32tob(gID)) == nil {
+			return ErrNotFound
+		}
+
+		// Collect the terms in the graph, so that we can remove
+		// those which are orphaned after the graph is gone.
+		ids := roaring.NewBitmap()
+		ids.Add(gID)
+		if err := scanIndex(graphIndex(tx, gID, bucketSPO), nil, func(s, p, o uint32) error {
+			ids.Add(s)
+			ids.Add(p)
+			ids.Add(o)
 			return nil
-		} else if err != nil {
+		}); err != nil {
 			return err
 		}
 
-		bkt := tx.Bucket(bucketSPO)
-
-		sp := make([]byte, 8)
-		copy(sp, u32tob(sID))
-		copy(sp[4:], u32tob(pID))
+		if err := addGraphCounts(tx, gID, -1); err != nil {
+			return err
+		}
+		if err := graphs.DeleteBucket(u32tob(gID)); err != nil {
+			return err
+		}
 
-		bitmap := roaring.NewBitmap()
-		bo := bkt.Get(sp)
-		if bo == nil {
-			return nil
+		it := ids.Iterator()
+		for it.HasNext() {
+			id := it.Next()
+			if termInUse(tx, id) {
+				continue
+			}
+			if err := db.removeTerm(tx, id); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
 
-		_, err = bitmap.ReadFrom(bytes.NewReader(bo))
-		if err != nil {
+// ChangeBase changes the base URI of the database to newBase, and returns
+// the number of terms re-encoded; URIs starting with the new base are stored
+// relative to it, and URIs starting with the old base only, as absolute.
+// The triple indices refer to term IDs, and are left as they are.
+// Transactions started before the change is committed keep seeing the
+// terms with the old base.
+func (db *DB) ChangeBase(newBase string) (n int, err error) {
+	if newBase == "" {
+		return 0, errors.New("base URI cannot be empty")
+	}
+	db.muRegister.Lock()
+	defer db.muRegister.Unlock()
+
+	oldBase := db.base
+	var since int
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		t := db.namespaces()
+		if n, err = db.reencodeURIs(tx, func(uri string) []byte {
+			return encodeURI(t, newBase, uri)
+		}); err != nil {
 			return err
 		}
-
-		exists = bitmap.Contains(oID)
+		if err := tx.Bucket(bucketMeta).Put(metaBase, []byte(newBase)); err != nil {
+			return err
+		}
+		// The base is replaced before the transaction commits, so that
+		// no writer after it encodes URIs relative to the old base.
+		since = tx.ID()
+		db.setBase(newBase, since)
 		return nil
 	})
-	return exists, err
+	if err != nil {
+		db.setBase(oldBase, since)
+		return 0, err
+	}
+	return n, nil
 }
 
-// Describe returns a graph with all the triples where the given node
-// is subject. If asObject is true, it also includes the triples where
-// the node is object.
-func (db *DB) Describe(node rdf.URI, asObject bool) (*rdf.Graph, error) {
-	g := rdf.NewGraph()
-	err := db.kv.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(bucketIdxTerms)
-		bt := db.encode(node)
-		bs := bkt.Get(bt)
-		if bs == nil {
-			return nil
+// baseURI returns the current base URI.
+func (db *DB) baseURI() string {
+	db.muNS.RLock()
+	base := db.base
+	db.muNS.RUnlock()
+	return base
+}
+
+// setBase replaces the base URI, as of the transaction with the given ID;
+// see encodingAt. Encoded terms cached by imports are stale when the
+// encoding of URIs change.
+func (db *DB) setBase(base string, since int) {
+	db.muNS.Lock()
+	db.base = base
+	db.changeEncoding(since)
+	db.muNS.Unlock()
+	atomic.AddUint64(&db.removals, 1)
+}
+
+// encoding holds what terms are encoded with: the base URI, and the tables
+// of namespaces and datatypes.
+type encoding struct {
+	base string
+	ns   *nsTable
+	dts  *nsTable
+}
+
+// encoding returns the current encoding.
+func (db *DB) encoding() encoding {
+	db.muNS.RLock()
+	enc := encoding{base: db.base, ns: db.ns, dts: db.dts}
+	db.muNS.RUnlock()
+	return enc
+}
+
+// maxPastEncodings is the number of encodings of older snapshots kept.
+const maxPastEncodings = 16
+
+// encodingAt returns the encoding of the terms in the snapshot of the
+// transaction. The ID of a read-only transaction is the ID of the last
+// transaction committed before it started, so it is the current encoding,
+// unless the encoding has been changed since the transaction started.
+// Then it is read from the snapshot.
+func (db *DB) encodingAt(tx *bolt.Tx) encoding {
+	db.muNS.RLock()
+	enc := encoding{base: db.base, ns: db.ns, dts: db.dts}
+	current := tx.ID() >= db.encSince
+	past, ok := db.past[tx.ID()]
+	db.muNS.RUnlock()
+	if current {
+		return enc
+	}
+	if ok {
+		return past
+	}
+	enc = encoding{
+		base: string(tx.Bucket(bucketMeta).Get(metaBase)),
+		ns:   readTable(tx.Bucket(bucketNamespaces)),
+		dts:  readTable(tx.Bucket(bucketDatatypes)),
+	}
+	db.muNS.Lock()
+	if len(db.past) >= maxPastEncodings {
+		db.past = make(map[int]encoding)
+	}
+	db.past[tx.ID()] = enc
+	db.muNS.Unlock()
+	return enc
+}
+
+// changeEncoding records that the encoding is changed by the read-write
+// transaction with the given ID, so that the transactions which started
+// before it is committed read their encoding from their snapshots. If it is
+// rolled back, they keep doing so, which is slower, but correct. It must be
+// called with muNS locked.
+func (db *DB) changeEncoding(since int) {
+	if since > db.encSince {
+		db.encSince = since
+	}
+}
+
+// reencodeURIs encodes all the stored URIs with the given function, and
+// returns the number of terms whose encoding changed. The term IDs are kept,
+// so the triple indices are left as they are.
+func (db *DB) reencodeURIs(tx *bolt.Tx, encode func(uri string) []byte) (int, error) {
+	return reencodeTerms(tx, func(v []byte) ([]byte, error) {
+		if v[0] != 0x00 && v[0] != 0x01 && v[0] != 0xFD {
+			// Only URIs are stored relative to the base or a namespace
+			return v, nil
 		}
-		// seek in SPO index:
-		// WHERE { <node> ?p ?o }
-		sid := btou32(bs)
-		cur := tx.Bucket(bucketSPO).Cursor()
-	outerSPO:
-		for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
-			switch bytes.Compare(k[:4], bs) {
-			case 0:
-				bkt = tx.Bucket(bucketTerms)
-				b := bkt.Get(k[4:])
-				if b == nil {
-					return errors.New("bug: term ID in index, but not stored")
-				}
+		term, err := db.decode(tx, v)
+		if err != nil {
+			return nil, err
+		}
+		return encode(string(term.(rdf.URI))), nil
+	})
+}
 
-				// TODO get pred from cache
-				pred, err := db.decode(b)
-				if err != nil {
-					return err
-				}
-				bitmap := roaring.NewBitmap()
-				_, err = bitmap.ReadFrom(bytes.NewReader(v))
-				if err != nil {
-					return err
-				}
-				it := bitmap.Iterator()
-				for it.HasNext() {
-					o := it.Next()
-					b = bkt.Get(u32tob(o))
-					if b == nil {
-						return errors.New("bug: term ID in index, but not stored")
-					}
+// reencodeTerms replaces the encoding of every stored term with the encoding
+// returned by the given function, and returns the number of terms whose
+// encoding changed.
+func reencodeTerms(tx *bolt.Tx, encode func(v []byte) ([]byte, error)) (int, error) {
+	// Find the terms to re-encode before changing any of them.
+	var changes []termChange
+	if err := tx.Bucket(bucketTerms).ForEach(func(k, v []byte) error {
+		bt, err := encode(v)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(bt, v) {
+			// Keys and values are only valid until the bucket is modified
+			changes = append(changes, termChange{id: append([]byte(nil), k...), from: append([]byte(nil), v...), to: bt})
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return len(changes), putReencoded(tx, changes)
+}
 
-					obj, err := db.decode(b)
-					if err != nil {
-						return err
-					}
-					g.Insert(rdf.Triple{Subj: node, Pred: pred.(rdf.URI), Obj: obj})
-				}
-			case 1:
-				break outerSPO
-			}
+// termChange is a change of the encoding of the term with the given ID.
+type termChange struct{ id, from, to []byte }
+
+// putReencoded stores the new encodings of the terms.
+func putReencoded(tx *bolt.Tx, changes []termChange) error {
+	// The new encoding of a term may be the old encoding of
+	// another, so all the old ones are removed first.
+	terms, iterms := tx.Bucket(bucketTerms), tx.Bucket(bucketIdxTerms)
+	for _, c := range changes {
+		if err := iterms.Delete(c.from); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		if err := terms.Put(c.id, c.to); err != nil {
+			return err
 		}
+		if err := iterms.Put(c.to, c.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		if !asObject {
-			return nil
+// migrateTaggedLiterals re-encodes the literals with a language tag or a
+// custom datatype, whose length was stored as a single byte, using
+// encodeTagged. Lengths below 128 are encoded the same way as uvarints.
+// Lengths above 255 were truncated, and cannot be recovered.
+func migrateTaggedLiterals(_ *DB, tx *bolt.Tx) error {
+	_, err := reencodeTerms(tx, func(v []byte) ([]byte, error) {
+		if v[0] != 0x03 && v[0] != 0xFF {
+			return v, nil
 		}
-		// seek in OSP index:
-		// WHERE { ?s ?p <node> }
-		cur = tx.Bucket(bucketOSP).Cursor()
-	outerOSP:
-		for k, v := cur.Seek(u32tob(sid - 1)); k != nil; k, v = cur.Next() {
-			switch bytes.Compare(k[:4], bs) {
-			case 0:
-				bkt = tx.Bucket(bucketTerms)
-				b := bkt.Get(k[4:])
-				if b == nil {
-					return errors.New("bug: term ID in index, but not stored")
-				}
-				subj, err := db.decode(b)
-				if err != nil {
-					return err
-				}
-				bitmap := roaring.NewBitmap()
-				_, err = bitmap.ReadFrom(bytes.NewReader(v))
-				if err != nil {
-					return err
-				}
-				it := bitmap.Iterator()
-				for it.HasNext() {
-					o := it.Next()
-					b = bkt.Get(u32tob(o))
-					if b == nil {
-						return errors.New("bug: term ID in index, but not stored")
-					}
-					// TODO get pred from cache
-					pred, err := db.decode(b)
-					if err != nil {
-						return err
-					}
-					g.Insert(rdf.Triple{Subj: subj.(rdf.URI), Pred: pred.(rdf.URI), Obj: node})
-				}
-			case 1:
-				break outerOSP
-			}
+		if len(v) < 2 || len(v) < int(v[1])+2 {
+			return nil, fmt.Errorf("cannot decode literal: %v", v)
+		}
+		ll := int(v[1])
+		return encodeTagged(v[0], string(v[2:2+ll]), string(v[2+ll:])), nil
+	})
+	return err
+}
+
+// createGraph makes sure the named graph and its indices exists,
+// and returns the ID of the graph name.
+func (db *DB) createGraph(tx *bolt.Tx, name rdf.URI) (uint32, error) {
+	gID, err := db.addTerm(tx, name)
+	if err != nil {
+		return 0, err
+	}
+	return gID, createGraphID(tx, gID)
+}
+
+// createGraphID makes sure the indices of the graph with the given ID exists.
+func createGraphID(tx *bolt.Tx, gID uint32) error {
+	bkt, err := tx.Bucket(bucketGraphs).CreateBucketIfNotExists(u32tob(gID))
+	if err != nil {
+		return err
+	}
+	for _, idx := range [][]byte{bucketSPO, bucketOSP, bucketPOS} {
+		if _, err := bkt.CreateBucketIfNotExists(idx); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
+// targetGraph returns the ID of the graph to store triples in; the named
+// graph name, which is created if needed, or the default graph if name is empty.
+func (db *DB) targetGraph(tx *bolt.Tx, name rdf.URI) (uint32, error) {
+	if name == "" {
+		return 0, nil
+	}
+	return db.createGraph(tx, name)
+}
+
+// graphIDs returns the IDs of all graphs, starting with the default graph (0).
+func graphIDs(tx *bolt.Tx) ([]uint32, error) {
+	graphs := []uint32{0}
+	err := tx.Bucket(bucketGraphs).ForEach(func(k, _ []byte) error {
+		graphs = append(graphs, btou32(k))
 		return nil
 	})
+	return graphs, err
+}
 
-	return g, err
+// graphIndex returns the index bucket idx of the graph with ID g, where
+// 0 is the default graph. It returns nil if the named graph does not exist.
+func graphIndex(tx *bolt.Tx, g uint32, idx []byte) *bolt.Bucket {
+	if g == 0 {
+		return tx.Bucket(idx)
+	}
+	bkt := tx.Bucket(bucketGraphs).Bucket(u32tob(g))
+	if bkt == nil {
+		return nil
+	}
+	return bkt.Bucket(idx)
 }
 
 // Import imports triples from an Turtle stream, in batches of given size.
-// It will ignore triples with blank nodes and errors.
+// The stream is decompressed if it is compressed with gzip or bzip2.
+// It will ignore triples with errors. The labels of blank nodes are scoped
+// to the import, so that blank nodes from different imports never collide.
 // It returns the total number of triples imported.
 func (db *DB) Import(r io.Reader, batchSize int) (int, error) {
-	dec := rdf.NewDecoder(r)
+	rep, err := db.importBatches(r, ImportOptions{BatchSize: batchSize})
+	return rep.Stored + rep.Existing, err
+}
+
+// ImportIn is like Import, but stores the triples in the named graph name.
+func (db *DB) ImportIn(name rdf.URI, r io.Reader, batchSize int) (int, error) {
+	rep, err := db.importBatches(r, ImportOptions{BatchSize: batchSize, Graph: name})
+	return rep.Stored + rep.Existing, err
+}
+
+// importBatches decodes triples from r, and stores each batch of triples
+// in the graph given by the options.
+func (db *DB) importBatches(r io.Reader, opts ImportOptions) (ImportReport, error) {
+	var rep ImportReport
+	r, cp, err := db.startImport(r, opts)
+	if err != nil {
+		return rep, err
+	}
+	start := cp.Offset
+	cr := &countingReader{r: r}
+	dec := cp.decoder(cr)
 	g := rdf.NewGraph()
-	c := 0 // totalt count
 	i := 0 // current batch count
+	var p ImportProgress
+	store := func() error {
+		cp.Offset = start + dec.Offset()
+		cp.Base = dec.Base
+		cp.Prefixes = dec.Prefixes()
+		cp.Batch++
+		if err := db.learnNamespaces(opts.LearnNamespaces, cp.Prefixes); err != nil {
+			return err
+		}
+		var n int
+		err := db.kv.Update(func(tx *bolt.Tx) error {
+			gID, err := db.targetGraph(tx, opts.Graph)
+			if err != nil {
+				return err
+			}
+			if n, err = db.importGraph(tx, gID, g, cp.scope); err != nil {
+				return err
+			}
+			if opts.Source != "" {
+				return putCheckpoint(tx, opts.Source, cp)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		rep.Stored += n
+		rep.Existing += i - n
+		if opts.Progress != nil {
+			p.Batches++
+			p.Triples += i
+			p.Bytes = cr.n
+			opts.Progress(p)
+		}
+		i = 0
+		g = rdf.NewGraph()
+		return nil
+	}
 	for tr, err := dec.Decode(); err != io.EOF; tr, err = dec.Decode() {
+		if _, ok := err.(*rdf.DecodeError); err != nil && !ok {
+			// Reading or decompressing the input failed
+			return rep, err
+		}
 		if err != nil {
-			// log.Println(err.Error())
+			rep.Rejected++
+			if err = opts.reject(err, rep.Rejected); err != nil {
+				return rep, err
+			}
 			continue
 		}
 		g.Insert(tr)
+		rep.Read++
 		i++
-		if i == batchSize {
-			err = db.ImportGraph(g)
-			if err != nil {
-				return c, err
+		if i == opts.BatchSize {
+			if err := store(); err != nil {
+				return rep, err
 			}
-			c += i
-			i = 0
-			g = rdf.NewGraph()
 		}
 	}
-	if len(g.Nodes()) > 0 {
-		err := db.ImportGraph(g)
+	if i > 0 {
+		if err := store(); err != nil {
+			return rep, err
+		}
+	}
+	return rep, db.deleteCheckpoint(opts.Source)
+}
+
+// ImportGraph stores all the triples of the given graph in the default graph.
+// Like with Import, the labels of blank nodes are scoped to the import.
+func (db *DB) ImportGraph(g *rdf.Graph) error {
+	scope, err := db.newImportScope()
+	if err != nil {
+		return err
+	}
+	_, err = db.importGraphIn("", g, scope)
+	return err
+}
+
+// ImportGraphIn stores all the triples of the given graph in the named
+// graph name. The named graph is created if it does not exist.
+func (db *DB) ImportGraphIn(name rdf.URI, g *rdf.Graph) error {
+	scope, err := db.newImportScope()
+	if err != nil {
+		return err
+	}
+	_, err = db.importGraphIn(name, g, scope)
+	return err
+}
+
+// newImportScope returns a number unique to an import, used to
+// scope the labels of the imported blank nodes.
+func (db *DB) newImportScope() (scope uint64, err error) {
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		scope, err = tx.Bucket(bucketImports).NextSequence()
+		return err
+	})
+	return scope, err
+}
+
+// importGraphIn stores the graph in the named graph name, or in the default
+// graph if name is empty. It returns the number of triples not allready stored.
+func (db *DB) importGraphIn(name rdf.URI, g *rdf.Graph, scope uint64) (n int, err error) {
+	err = db.kv.Update(func(tx *bolt.Tx) error {
+		gID, err := db.targetGraph(tx, name)
 		if err != nil {
-			return c, err
+			return err
+		}
+		n, err = db.importGraph(tx, gID, g, scope)
+		return err
+	})
+	return n, err
+}
+
+// scopeBlank relabels the term if it is a blank node, making it unique to
+// the import with the given scope.
+func scopeBlank(t rdf.Term, scope uint64) rdf.Term {
+	if b, ok := t.(rdf.BlankNode); ok {
+		return rdf.BlankNode(fmt.Sprintf("b%d_%s", scope, b))
+	}
+	return t
+}
+
+// importGraph stores the triples of the graph in the graph with ID gID, and
+// returns the number of triples not allready stored. The IDs are collected
+// in a batch, so that each bitmap in the indices is only read and written once.
+func (db *DB) importGraph(tx *bolt.Tx, gID uint32, g *rdf.Graph, scope uint64) (int, error) {
+	// Objects are often repeated, ex: the classes in rdf:type statements
+	objIDs := make(map[rdf.Term]uint32)
+
+	b := newTripleBatch()
+	for subj, props := range g.Nodes() {
+
+		sID, err := db.addTerm(tx, scopeBlank(subj, scope))
+		if err != nil {
+			return 0, err
+		}
+
+		for pred, terms := range props {
+			pID, err := db.addPred(tx, pred)
+			if err != nil {
+				return 0, err
+			}
+
+			for _, obj := range terms {
+				obj = scopeBlank(obj, scope)
+				oID, ok := objIDs[obj]
+				if !ok {
+					oID, err = db.addTerm(tx, obj)
+					if err != nil {
+						return 0, err
+					}
+					objIDs[obj] = oID
+				}
+				b.add(sID, pID, oID)
+			}
+		}
+	}
+	return b.store(tx, gID)
+}
+
+// tripleBatch collects triples to be stored, as the IDs to be added to
+// the bitmaps of the composite keys in each of the SPO, OSP and POS indices.
+// The composite keys are represented as uint64, which sorts in the same
+// order as the 8-byte keys stored in the index buckets.
+type tripleBatch [3]map[uint64]*roaring.Bitmap
+
+func newTripleBatch() tripleBatch {
+	var b tripleBatch
+	for i := range b {
+		b[i] = make(map[uint64]*roaring.Bitmap)
+	}
+	return b
+}
+
+// add adds the triple with the given IDs to the batch.
+func (b tripleBatch) add(s, p, o uint32) {
+	for i, ids := range [3][3]uint32{{s, p, o}, {o, s, p}, {p, o, s}} {
+		k := uint64(ids[0])<<32 | uint64(ids[1])
+		bitmap, ok := b[i][k]
+		if !ok {
+			bitmap = roaring.NewBitmap()
+			b[i][k] = bitmap
 		}
-		c += i
+		bitmap.Add(ids[2])
 	}
-	return c, nil
 }
 
-func (db *DB) ImportGraph(g *rdf.Graph) error {
-	return db.kv.Update(func(tx *bolt.Tx) error {
-		for subj, props := range g.Nodes() {
-
-			sID, err := db.addTerm(tx, subj)
-			if err != nil {
-				return err
-			}
+// store merges the batch into the indices of the graph with ID g, and
+// returns the number of triples not allready stored.
+func (b tripleBatch) store(tx *bolt.Tx, g uint32) (int, error) {
+	triples := 0
+	for i, idx := range [][]byte{bucketSPO, bucketOSP, bucketPOS} {
+		bkt := graphIndex(tx, g, idx)
+
+		// Writing the keys in order is faster, as bolt
+		// doesn't have to move around as much.
+		keys := make(uint64s, 0, len(b[i]))
+		for k := range b[i] {
+			keys = append(keys, k)
+		}
+		sort.Sort(keys)
 
-			for pred, terms := range props {
-				pID, err := db.addTerm(tx, pred)
-				if err != nil {
-					return err
+		newKeys, size := 0, 0
+		for _, k := range keys {
+			key := compositeKey(uint32(k>>32), uint32(k))
+			bitmap := roaring.NewBitmap()
+			bo := bkt.Get(key)
+			if bo != nil {
+				if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+					return 0, err
 				}
+			}
+			n := bitmap.GetCardinality()
+			bitmap.Or(b[i][k])
+			added := int(bitmap.GetCardinality() - n)
+			if added == 0 {
+				// All triples allready stored
+				continue
+			}
 
-				for _, obj := range terms {
-					// TODO batch bitmap operations for all obj in terms
-					oID, err := db.addTerm(tx, obj)
-					if err != nil {
-						return err
-					}
+			var buf bytes.Buffer
+			if _, err := bitmap.WriteTo(&buf); err != nil {
+				return 0, err
+			}
+			if err := bkt.Put(key, buf.Bytes()); err != nil {
+				return 0, err
+			}
 
-					err = db.storeTriple(tx, sID, pID, oID)
-					if err != nil {
-						return err
-					}
-				}
+			if bo == nil {
+				newKeys++
+			}
+			size += buf.Len() - len(bo)
+			if i == 0 {
+				triples += added
 			}
 		}
-		return nil
-	})
+
+		keysKey, sizeKey := indexCountKeys(idx)
+		if err := addCount(tx, keysKey, newKeys); err != nil {
+			return 0, err
+		}
+		if err := addCount(tx, sizeKey, size); err != nil {
+			return 0, err
+		}
+	}
+	return triples, addCount(tx, metaNumTriples, triples)
 }
 
+// uint64s is a slice of uint64, sortable in increasing order.
+type uint64s []uint64
+
+func (u uint64s) Len() int           { return len(u) }
+func (u uint64s) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u uint64s) Less(i, j int) bool { return u[i] < u[j] }
+
 // Dump writes the entire database as a Turtle serialization to the given writer.
 func (db *DB) Dump(to io.Writer) error {
-	// TODO getTerm without expanding base URI?
-	// base is prefixed added, but then stripped again here
-	w := bufio.NewWriter(to)
-	w.WriteString("@base <")
-	w.WriteString(db.base)
-	w.WriteString(">")
 	return db.kv.View(func(tx *bolt.Tx) error {
+		// TODO getTerm without expanding base URI?
+		// base is prefixed added, but then stripped again here
+		base := db.encodingAt(tx).base
+		w := bufio.NewWriter(to)
 		defer w.Flush()
+		w.WriteString("@base <")
+		w.WriteString(base)
+		w.WriteString(">")
 
 		var curSubj uint32
-		var subj, pred, obj rdf.Term
+		var subj, obj rdf.Term
+		var pred rdf.URI
 
 		bkt := tx.Bucket(bucketSPO)
 		if err := bkt.ForEach(func(k, v []byte) error {
@@ -450,9 +1428,15 @@ func (db *DB) Dump(to io.Writer) error {
 				if subj, err = db.getTerm(tx, sID); err != nil {
 					return err
 				}
-				w.WriteRune('<')
-				w.WriteString(strings.TrimPrefix(subj.String(), db.base))
-				w.WriteString("> ")
+				if _, ok := subj.(rdf.BlankNode); ok {
+					w.WriteString("_:")
+					w.WriteString(subj.String())
+					w.WriteRune(' ')
+				} else {
+					w.WriteRune('<')
+					w.WriteString(strings.TrimPrefix(subj.String(), base))
+					w.WriteString("> ")
+				}
 			} else {
 				// continue with same subject
 				w.WriteString(" ;\n\t")
@@ -460,14 +1444,14 @@ func (db *DB) Dump(to io.Writer) error {
 
 			pID := btou32(k[4:])
 
-			if pred, err = db.getTerm(tx, pID); err != nil {
+			if pred, err = db.getPred(tx, pID); err != nil {
 				return err
 			}
 			if pred == rdf.RDFtype {
 				w.WriteString("a ")
 			} else {
 				w.WriteRune('<')
-				w.WriteString(strings.TrimPrefix(pred.String(), db.base))
+				w.WriteString(strings.TrimPrefix(pred.String(), base))
 				w.WriteString("> ")
 			}
 
@@ -488,8 +1472,11 @@ func (db *DB) Dump(to io.Writer) error {
 				switch t := obj.(type) {
 				case rdf.URI:
 					w.WriteRune('<')
-					w.WriteString(strings.TrimPrefix(obj.String(), db.base))
+					w.WriteString(strings.TrimPrefix(obj.String(), base))
 					w.WriteRune('>')
+				case rdf.BlankNode:
+					w.WriteString("_:")
+					w.WriteString(t.String())
 				case rdf.Literal:
 					// TODO bench & optimize
 					switch t.DataType() {
@@ -498,7 +1485,7 @@ func (db *DB) Dump(to io.Writer) error {
 					case rdf.XSDstring:
 						fmt.Fprintf(w, "%q", t.String())
 					default:
-						fmt.Fprintf(w, "%q^^<%s>", t.String(), strings.TrimPrefix(t.DataType().String(), db.base))
+						fmt.Fprintf(w, "%q^^<%s>", t.String(), strings.TrimPrefix(t.DataType().String(), base))
 					}
 				}
 				c++
@@ -533,11 +1520,10 @@ func (db *DB) forEach(fn func(rdf.Triple) error) error {
 			if term, err = db.getTerm(tx, sID); err != nil {
 				return err
 			}
-			tr.Subj = term.(rdf.URI)
-			if term, err = db.getTerm(tx, pID); err != nil {
+			tr.Subj = term.(rdf.Subject)
+			if tr.Pred, err = db.getPred(tx, pID); err != nil {
 				return err
 			}
-			tr.Pred = term.(rdf.URI)
 
 			bitmap := roaring.NewBitmap()
 			if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
@@ -565,8 +1551,11 @@ func (db *DB) forEach(fn func(rdf.Triple) error) error {
 }
 
 func (db *DB) addTerm(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
-	bt := db.encode(term)
+	return db.addTermb(tx, db.encode(tx, term))
+}
 
+// addTermb is like addTerm, but takes an encoded term.
+func (db *DB) addTermb(tx *bolt.Tx, bt []byte) (id uint32, err error) {
 	if id, err = db.getIDb(tx, bt); err == nil {
 		// Term is allready in database
 		return id, nil
@@ -575,18 +1564,25 @@ func (db *DB) addTerm(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
 		return 0, err
 	}
 
-	// get a new ID
+	// get a new ID, reusing the ID of a deleted term if possible
 	bkt := tx.Bucket(bucketTerms)
-	n, err := bkt.NextSequence()
-	if err != nil {
+	if id, err = reuseID(tx); err != nil {
 		return 0, err
 	}
-	if n > MaxTerms {
-		return 0, ErrDBFull
+	if id != 0 {
+		db.idChanged(tx)
 	}
-
-	id = uint32(n)
-	idb := u32tob(uint32(n))
+	if id == 0 {
+		n, err := bkt.NextSequence()
+		if err != nil {
+			return 0, err
+		}
+		if n > MaxTerms {
+			return 0, ErrDBFull
+		}
+		id = uint32(n)
+	}
+	idb := u32tob(id)
 
 	// store term and index it
 	err = bkt.Put(idb, bt)
@@ -598,7 +1594,50 @@ func (db *DB) addTerm(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
 	return id, err
 }
 
-func (db *DB) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
+// reuseID removes the lowest ID from the free-list and returns it.
+// It returns 0 if the free-list is empty.
+func reuseID(tx *bolt.Tx) (uint32, error) {
+	bkt := tx.Bucket(bucketFreeIDs)
+	bo := bkt.Get(keyFreeIDs)
+	if bo == nil {
+		return 0, nil
+	}
+	bitmap := roaring.NewBitmap()
+	if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+		return 0, err
+	}
+	id := bitmap.Minimum()
+	bitmap.Remove(id)
+	if bitmap.IsEmpty() {
+		return id, bkt.Delete(keyFreeIDs)
+	}
+	var b bytes.Buffer
+	if _, err := bitmap.WriteTo(&b); err != nil {
+		return 0, err
+	}
+	return id, bkt.Put(keyFreeIDs, b.Bytes())
+}
+
+// freeID adds the ID of a deleted term to the free-list.
+func freeID(tx *bolt.Tx, id uint32) error {
+	bkt := tx.Bucket(bucketFreeIDs)
+	bitmap := roaring.NewBitmap()
+	if bo := bkt.Get(keyFreeIDs); bo != nil {
+		if _, err := bitmap.ReadFrom(bytes.NewReader(bo)); err != nil {
+			return err
+		}
+	}
+	bitmap.Add(id)
+	var b bytes.Buffer
+	if _, err := bitmap.WriteTo(&b); err != nil {
+		return err
+	}
+	return bkt.Put(keyFreeIDs, b.Bytes())
+}
+
+// storeTriple stores a triple in the indices of the graph with ID g, where
+// 0 is the default graph.
+func (db *DB) storeTriple(tx *bolt.Tx, g, s, p, o uint32) error {
 	indices := []struct {
 		k1 uint32
 		k2 uint32
@@ -613,7 +1652,7 @@ func (db *DB) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
 	key := make([]byte, 8)
 
 	for _, i := range indices {
-		bkt := tx.Bucket(i.bk)
+		bkt := graphIndex(tx, g, i.bk)
 		copy(key, u32tob(i.k1))
 		copy(key[4:], u32tob(i.k2))
 		bitmap := roaring.NewBitmap()
@@ -640,16 +1679,33 @@ func (db *DB) storeTriple(tx *bolt.Tx, s, p, o uint32) error {
 		if err != nil {
 			return err
 		}
+
+		keys, size := indexCountKeys(i.bk)
+		if bo == nil {
+			if err := addCount(tx, keys, 1); err != nil {
+				return err
+			}
+		}
+		if err := addCount(tx, size, b.Len()-len(bo)); err != nil {
+			return err
+		}
 	}
 
-	//atomic.AddInt64(&db.numTr, 1)
+	return addCount(tx, metaNumTriples, 1)
+}
 
-	return nil
+// removeTriple removes a triple from the indices of the graph with ID g. If the
+// triple contains any terms unique to that triple, they will also be removed.
+func (db *DB) removeTriple(tx *bolt.Tx, g, s, p, o uint32) error {
+	if err := unindexTriple(tx, g, s, p, o); err != nil {
+		return err
+	}
+	return db.removeOrphanedTerms(tx, s, p, o)
 }
 
-// removeTriple removes a triple from the indices. If the triple
-// contains any terms unique to that triple, they will also be removed.
-func (db *DB) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
+// unindexTriple removes a triple from the indices of the graph with ID g,
+// keeping its terms.
+func unindexTriple(tx *bolt.Tx, g, s, p, o uint32) error {
 	// TODO think about what to do if present in one index but
 	// not in another: maybe panic? Cause It's a bug that should be fixed.
 
@@ -666,7 +1722,10 @@ func (db *DB) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 
 	key := make([]byte, 8)
 	for _, i := range indices {
-		bkt := tx.Bucket(i.bk)
+		bkt := graphIndex(tx, g, i.bk)
+		if bkt == nil {
+			return ErrNotFound
+		}
 		copy(key, u32tob(i.k1))
 		copy(key[4:], u32tob(i.k2))
 
@@ -686,12 +1745,19 @@ func (db *DB) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 			// TODO should never happen, return bug error?
 			return ErrNotFound
 		}
+		keys, size := indexCountKeys(i.bk)
 		// Remove from index if bitmap is empty
 		if bitmap.GetCardinality() == 0 {
 			err = bkt.Delete(key)
 			if err != nil {
 				return err
 			}
+			if err := addCount(tx, keys, -1); err != nil {
+				return err
+			}
+			if err := addCount(tx, size, -len(bo)); err != nil {
+				return err
+			}
 		} else {
 			var b bytes.Buffer
 			_, err = bitmap.WriteTo(&b)
@@ -702,12 +1768,237 @@ func (db *DB) removeTriple(tx *bolt.Tx, s, p, o uint32) error {
 			if err != nil {
 				return err
 			}
+			if err := addCount(tx, size, b.Len()-len(bo)); err != nil {
+				return err
+			}
 		}
 	}
 
-	//atomic.AddInt64(&db.numTr, -1)
+	return addCount(tx, metaNumTriples, -1)
+}
 
-	return db.removeOrphanedTerms(tx, s, p, o)
+// renameTerm stores the term in place of the term with the given ID, so that
+// all triples using the ID refer to the new term. The term must not be stored.
+func (db *DB) renameTerm(tx *bolt.Tx, id uint32, term rdf.Term) error {
+	idb := u32tob(id)
+	terms := tx.Bucket(bucketTerms)
+	old := terms.Get(idb)
+	if old == nil {
+		return errors.New("bug: renameTerm: Term does not exist")
+	}
+	bt := db.encode(tx, term)
+	iterms := tx.Bucket(bucketIdxTerms)
+	if err := iterms.Delete(old); err != nil {
+		return err
+	}
+	if err := iterms.Put(bt, idb); err != nil {
+		return err
+	}
+	if err := terms.Put(idb, bt); err != nil {
+		return err
+	}
+	atomic.AddUint64(&db.removals, 1)
+	db.idChanged(tx)
+
+	// The term will be cached under its new name when next used as predicate.
+	db.uncachePred(id)
+	tx.OnCommit(func() { db.uncachePred(id) })
+	return nil
+}
+
+// mergeTerms replaces the term with ID drop by the term with ID keep in all
+// triples, in all graphs, and removes the term drop. If drop names a graph,
+// all its triples are moved to the graph named by keep.
+func (db *DB) mergeTerms(tx *bolt.Tx, keep, drop uint32) error {
+	graphs, err := graphIDs(tx)
+	if err != nil {
+		return err
+	}
+	for _, g := range graphs {
+		to := g
+		var trs [][3]uint32
+		if g == drop {
+			to = keep
+			if err := createGraphID(tx, to); err != nil {
+				return err
+			}
+			err = scanIndex(graphIndex(tx, g, bucketSPO), nil, func(s, p, o uint32) error {
+				trs = append(trs, [3]uint32{s, p, o})
+				return nil
+			})
+		} else {
+			trs, err = termTriples(tx, g, drop)
+		}
+		if err != nil {
+			return err
+		}
+
+		// All triples are removed before the merged ones are stored, so that
+		// triples which become equal to another are counted correctly.
+		for _, tr := range trs {
+			if err := unindexTriple(tx, g, tr[0], tr[1], tr[2]); err != nil {
+				return err
+			}
+		}
+		for _, tr := range trs {
+			for i := range tr {
+				if tr[i] == drop {
+					tr[i] = keep
+				}
+			}
+			if err := db.storeTriple(tx, to, tr[0], tr[1], tr[2]); err != nil {
+				return err
+			}
+		}
+		if g == drop {
+			if err := tx.Bucket(bucketGraphs).DeleteBucket(u32tob(g)); err != nil {
+				return err
+			}
+		}
+	}
+	return db.removeTerm(tx, drop)
+}
+
+// termTriples returns the triples in the graph with ID g where the term with
+// the given ID is subject, predicate or object.
+func termTriples(tx *bolt.Tx, g, id uint32) ([][3]uint32, error) {
+	var trs [][3]uint32
+	seen := make(map[[3]uint32]bool)
+	add := func(s, p, o uint32) error {
+		tr := [3]uint32{s, p, o}
+		if !seen[tr] {
+			seen[tr] = true
+			trs = append(trs, tr)
+		}
+		return nil
+	}
+	prefix := u32tob(id)
+	if err := scanIndex(graphIndex(tx, g, bucketSPO), prefix, add); err != nil {
+		return nil, err
+	}
+	if err := scanIndex(graphIndex(tx, g, bucketOSP), prefix, func(o, s, p uint32) error {
+		return add(s, p, o)
+	}); err != nil {
+		return nil, err
+	}
+	if err := scanIndex(graphIndex(tx, g, bucketPOS), prefix, func(p, o, s uint32) error {
+		return add(s, p, o)
+	}); err != nil {
+		return nil, err
+	}
+	return trs, nil
+}
+
+// removeTriples removes the given triples, as found by matchIDs, from the
+// graph with ID g, and returns the number of triples removed.
+func (db *DB) removeTriples(tx *bolt.Tx, g uint32, trs [][3]uint32) (int, error) {
+	for _, tr := range trs {
+		if err := db.removeTriple(tx, g, tr[0], tr[1], tr[2]); err != nil {
+			return 0, err
+		}
+	}
+	return len(trs), nil
+}
+
+// indexCountKeys returns the keys in the metadata bucket of the
+// counters of keys and bitmap bytes of the given index.
+func indexCountKeys(idx []byte) (keys, size []byte) {
+	return []byte(string(idx) + ".keys"), []byte(string(idx) + ".bytes")
+}
+
+// getCount returns the counter stored under key in the metadata bucket.
+func getCount(tx *bolt.Tx, key []byte) int {
+	b := tx.Bucket(bucketMeta).Get(key)
+	if b == nil {
+		return 0
+	}
+	return int(int64(binary.BigEndian.Uint64(b)))
+}
+
+// addCount adds delta to the counter stored under key in the metadata bucket.
+func addCount(tx *bolt.Tx, key []byte, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	n := getCount(tx, key) + delta
+	return putUint64(tx.Bucket(bucketMeta), key, uint64(int64(n)))
+}
+
+// putUint64 stores the number under the given key, big endian.
+func putUint64(bkt *bolt.Bucket, key []byte, n uint64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return bkt.Put(key, b)
+}
+
+// countIndex returns the number of keys, the total size of the bitmaps,
+// and the total number of IDs in the bitmaps of an index bucket.
+func countIndex(bkt *bolt.Bucket) (keys, size, ids int, err error) {
+	err = bkt.ForEach(func(k, v []byte) error {
+		bitmap := roaring.NewBitmap()
+		if _, err := bitmap.ReadFrom(bytes.NewReader(v)); err != nil {
+			return err
+		}
+		keys++
+		size += len(v)
+		ids += int(bitmap.GetCardinality())
+		return nil
+	})
+	return keys, size, ids, err
+}
+
+// addGraphCounts adds the counts of the indices of the graph with ID g,
+// multiplied by sign, to the counters in the metadata bucket.
+func addGraphCounts(tx *bolt.Tx, g uint32, sign int) error {
+	for _, idx := range [][]byte{bucketSPO, bucketOSP, bucketPOS} {
+		keys, size, ids, err := countIndex(graphIndex(tx, g, idx))
+		if err != nil {
+			return err
+		}
+		keysKey, sizeKey := indexCountKeys(idx)
+		if err := addCount(tx, keysKey, sign*keys); err != nil {
+			return err
+		}
+		if err := addCount(tx, sizeKey, sign*size); err != nil {
+			return err
+		}
+		if bytes.Equal(idx, bucketSPO) {
+			if err := addCount(tx, metaNumTriples, sign*ids); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recount resets the counters in the metadata bucket by scanning
+// the indices of all graphs.
+func recount(tx *bolt.Tx) error {
+	meta := tx.Bucket(bucketMeta)
+	b := make([]byte, 8)
+	if err := meta.Put(metaNumTriples, b); err != nil {
+		return err
+	}
+	for _, idx := range [][]byte{bucketSPO, bucketOSP, bucketPOS} {
+		keys, size := indexCountKeys(idx)
+		if err := meta.Put(keys, b); err != nil {
+			return err
+		}
+		if err := meta.Put(size, b); err != nil {
+			return err
+		}
+	}
+
+	graphs, err := graphIDs(tx)
+	if err != nil {
+		return err
+	}
+	for _, g := range graphs {
+		if err := addGraphCounts(tx, g, 1); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (db *DB) removeTerm(tx *bolt.Tx, termID uint32) error {
@@ -726,17 +2017,24 @@ func (db *DB) removeTerm(tx *bolt.Tx, termID uint32) error {
 	if err != nil {
 		return err
 	}
+	if err := freeID(tx, termID); err != nil {
+		return err
+	}
+	atomic.AddUint64(&db.removals, 1)
+
+	// Remove the term from the predicate cache right away, so that it is not
+	// used later in this transaction, and again when the transaction is committed,
+	// in case it was cached by an earlier insert in this transaction.
+	db.uncachePred(termID)
+	tx.OnCommit(func() { db.uncachePred(termID) })
 	return nil
 }
 
 // removeOrphanedTerms removes any of the given Terms if they are no longer
-// part of any triple.
+// part of any triple, in any graph.
 func (db *DB) removeOrphanedTerms(tx *bolt.Tx, s, p, o uint32) error {
-	// TODO by now we don't know whether object is a Literal or and URI.
-	// If we knew it to be a Literal, checking the OSP index would suffice.
-
 	for _, id := range unique(s, p, o) {
-		if notInIndex(tx, id, bucketSPO) && notInIndex(tx, id, bucketOSP) && notInIndex(tx, id, bucketPOS) {
+		if !termInUse(tx, id) {
 			err := db.removeTerm(tx, id)
 			if err != nil {
 				if err == ErrNotFound {
@@ -763,8 +2061,38 @@ func unique(s, p, o uint32) []uint32 {
 	return res
 }
 
-func notInIndex(tx *bolt.Tx, id uint32, idx []byte) bool {
-	cur := tx.Bucket(idx).Cursor()
+// termInUse checks if the term with the given ID is part of any triple in
+// the default graph or in any of the named graphs, or if it names a graph.
+func termInUse(tx *bolt.Tx, id uint32) bool {
+	// TODO by now we don't know whether object is a Literal or and URI.
+	// If we knew it to be a Literal, checking the OSP index would suffice.
+	inGraph := func(g uint32) bool {
+		for _, idx := range [][]byte{bucketSPO, bucketOSP, bucketPOS} {
+			if !notInIndex(graphIndex(tx, g, idx), id) {
+				return true
+			}
+		}
+		return false
+	}
+
+	graphs := tx.Bucket(bucketGraphs)
+	if graphs.Bucket(u32tob(id)) != nil {
+		return true
+	}
+	if inGraph(0) {
+		return true
+	}
+	cur := graphs.Cursor()
+	for k, _ := cur.First(); k != nil; k, _ = cur.Next() {
+		if inGraph(btou32(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func notInIndex(bkt *bolt.Bucket, id uint32) bool {
+	cur := bkt.Cursor()
 	for k, _ := cur.Seek(u32tob(id - 1)); k != nil; k, _ = cur.Next() {
 		switch bytes.Compare(k[:4], u32tob(id)) {
 		case 0:
@@ -776,9 +2104,105 @@ func notInIndex(tx *bolt.Tx, id uint32, idx []byte) bool {
 	return true
 }
 
+// loadPredicates fills the predicate cache with all the predicates
+// in the POS indices of the default graph and the named graphs.
+func (db *DB) loadPredicates(tx *bolt.Tx) error {
+	graphs, err := graphIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range graphs {
+		cur := graphIndex(tx, g, bucketPOS).Cursor()
+		for k, _ := cur.First(); k != nil; {
+			pID := btou32(k[:4])
+			term, err := db.getTerm(tx, pID)
+			if err != nil {
+				return err
+			}
+			db.pred.Add(term.(rdf.URI), pID)
+			if pID == MaxTerms {
+				break
+			}
+			// skip to next predicate
+			k, _ = cur.Seek(u32tob(pID + 1))
+		}
+	}
+	return nil
+}
+
+// addPred stores the predicate if it is not allready stored, and returns
+// its ID. The predicate is cached when the transaction is committed.
+func (db *DB) addPred(tx *bolt.Tx, pred rdf.URI) (uint32, error) {
+	db.muPred.RLock()
+	id, ok := db.pred.GetID(pred)
+	db.muPred.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := db.addTerm(tx, pred)
+	if err != nil {
+		return 0, err
+	}
+	tx.OnCommit(func() {
+		db.muPred.Lock()
+		db.pred.Add(pred, id)
+		db.muPred.Unlock()
+	})
+	return id, nil
+}
+
+// getPredID returns the ID of the given predicate.
+func (db *DB) getPredID(tx *bolt.Tx, pred rdf.URI) (uint32, error) {
+	db.muPred.RLock()
+	id, ok := db.pred.GetID(pred)
+	ok = ok && tx.ID() >= db.predSince
+	db.muPred.RUnlock()
+	if ok {
+		return id, nil
+	}
+	return db.getID(tx, pred)
+}
+
+// getPred returns the predicate with the given ID.
+func (db *DB) getPred(tx *bolt.Tx, id uint32) (rdf.URI, error) {
+	db.muPred.RLock()
+	pred, ok := db.pred.GetURI(id)
+	ok = ok && tx.ID() >= db.predSince
+	db.muPred.RUnlock()
+	if ok {
+		return pred, nil
+	}
+	term, err := db.getTerm(tx, id)
+	if err != nil {
+		return "", err
+	}
+	return term.(rdf.URI), nil
+}
+
+// uncachePred removes the term with the given ID from the predicate cache.
+func (db *DB) uncachePred(id uint32) {
+	db.muPred.Lock()
+	db.pred.DeleteID(id)
+	db.muPred.Unlock()
+}
+
+// idChanged records that the read-write transaction tx gives a term ID to
+// another term. The ID of a read-only transaction is the ID of the last
+// transaction committed before it started, so the transactions which
+// started before tx is committed no longer use the predicate cache. If tx
+// is rolled back, they keep looking up predicates in their snapshots,
+// which is slower, but correct.
+func (db *DB) idChanged(tx *bolt.Tx) {
+	db.muPred.Lock()
+	db.predSince = tx.ID()
+	db.muPred.Unlock()
+}
+
 func (db *DB) getID(tx *bolt.Tx, term rdf.Term) (id uint32, err error) {
 	bkt := tx.Bucket(bucketIdxTerms)
-	bt := db.encode(term)
+	bt := db.encode(tx, term)
 	b := bkt.Get(bt)
 	if b == nil {
 		err = ErrNotFound
@@ -806,81 +2230,106 @@ func (db *DB) getTerm(tx *bolt.Tx, id uint32) (rdf.Term, error) {
 	if b == nil {
 		return nil, ErrNotFound
 	}
-	return db.decode(b)
+	return db.decode(tx, b)
+}
+
+// encode encodes the term with the encoding of the snapshot of the transaction.
+func (db *DB) encode(tx *bolt.Tx, t rdf.Term) []byte {
+	return db.encodingAt(tx).encode(t)
+}
+
+// decode decodes the term with the encoding of the snapshot of the transaction.
+func (db *DB) decode(tx *bolt.Tx, b []byte) (rdf.Term, error) {
+	return db.encodingAt(tx).decode(b)
 }
 
-func (db *DB) encode(t rdf.Term) []byte {
+func (e encoding) encode(t rdf.Term) []byte {
 	switch term := t.(type) {
 	case rdf.URI:
-		if strings.HasPrefix(string(term), db.base) {
-			l := len(db.base)
-			b := make([]byte, len(term)-l+1)
-			copy(b[1:], string(term)[l:])
-			return b
-		}
+		return encodeURI(e.ns, e.base, string(term))
+	case rdf.BlankNode:
 		b := make([]byte, len(term)+1)
-		b[0] = 0x01
+		b[0] = 0xFE
 		copy(b[1:], string(term))
 		return b
 	case rdf.Literal:
-		var dt byte
-		switch term.DataType() {
-		case rdf.XSDstring:
-			dt = 0x02
-		case rdf.RDFlangString:
-			ll := len(term.Lang())
-			b := make([]byte, len(term.String())+ll+2)
-			b[0] = 0x03
-			b[1] = uint8(ll)
-			copy(b[2:], []byte(term.Lang()))
-			copy(b[2+ll:], []byte(term.String()))
-			return b
-		case rdf.XSDboolean:
-			dt = 0x04
-		case rdf.XSDbyte:
-			dt = 0x05
-		case rdf.XSDint:
-			dt = 0x06
-		case rdf.XSDshort:
-			dt = 0x07
-		case rdf.XSDlong:
-			dt = 0x08
-		case rdf.XSDinteger:
-			dt = 0x09
-		case rdf.XSDunsignedShort:
-			dt = 0x0A
-		case rdf.XSDunsignedInt:
-			dt = 0x0B
-		case rdf.XSDunsignedLong:
-			dt = 0x0C
-		case rdf.XSDunsignedByte:
-			dt = 0x0D
-		case rdf.XSDfloat:
-			dt = 0x0E
-		case rdf.XSDdouble:
-			dt = 0x0F
-		case rdf.XSDdateTimeStamp:
-			dt = 0x10
-		default:
-			ll := len(term.DataType())
-			b := make([]byte, len(term.String())+ll+2)
-			b[0] = 0xFF
-			b[1] = uint8(ll)
-			copy(b[2:], []byte(term.DataType()))
-			copy(b[2+ll:], []byte(term.String()))
-			return b
-
-		}
-		b := make([]byte, len(term.String())+1)
-		b[0] = dt
-		copy(b[1:], string(term.String()))
-		return b
+		return encodeLiteral(e.dts, term)
 	}
 
 	panic("unreachable")
 }
 
-func (db *DB) decode(b []byte) (rdf.Term, error) {
+// encodeLiteral encodes a literal with the code of the built-in datatype,
+// or of the datatype in the table, or else with the datatype URI inline.
+func encodeLiteral(t *nsTable, lit rdf.Literal) []byte {
+	var dt byte
+	switch lit.DataType() {
+	case rdf.XSDstring:
+		dt = 0x02
+	case rdf.RDFlangString:
+		return encodeTagged(0x03, lit.Lang(), lit.String())
+	case rdf.XSDboolean:
+		dt = 0x04
+	case rdf.XSDbyte:
+		dt = 0x05
+	case rdf.XSDint:
+		dt = 0x06
+	case rdf.XSDshort:
+		dt = 0x07
+	case rdf.XSDlong:
+		dt = 0x08
+	case rdf.XSDinteger:
+		dt = 0x09
+	case rdf.XSDunsignedShort:
+		dt = 0x0A
+	case rdf.XSDunsignedInt:
+		dt = 0x0B
+	case rdf.XSDunsignedLong:
+		dt = 0x0C
+	case rdf.XSDunsignedByte:
+		dt = 0x0D
+	case rdf.XSDfloat:
+		dt = 0x0E
+	case rdf.XSDdouble:
+		dt = 0x0F
+	case rdf.XSDdateTimeStamp:
+		dt = 0x10
+	case rdf.XSDdate:
+		dt = 0x11
+	case rdf.XSDdateTime:
+		dt = 0x12
+	case rdf.XSDtime:
+		dt = 0x13
+	case rdf.XSDdecimal:
+		dt = 0x14
+	case rdf.XSDanyURI:
+		dt = 0x15
+	case rdf.XSDgYear:
+		dt = 0x16
+	case rdf.XSDgYearMonth:
+		dt = 0x17
+	case rdf.XSDduration:
+		dt = 0x18
+	case rdf.XSDnonNegativeInteger:
+		dt = 0x19
+	default:
+		id, ok := t.ids[string(lit.DataType())]
+		if !ok {
+			return encodeTagged(0xFF, string(lit.DataType()), lit.String())
+		}
+		b := make([]byte, 1+binary.MaxVarintLen32+len(lit.String()))
+		b[0] = 0xFC
+		n := 1 + binary.PutUvarint(b[1:], uint64(id))
+		n += copy(b[n:], lit.String())
+		return b[:n]
+	}
+	b := make([]byte, len(lit.String())+1)
+	b[0] = dt
+	copy(b[1:], lit.String())
+	return b
+}
+
+func (e encoding) decode(b []byte) (rdf.Term, error) {
 	// We control the encoding, so the only way for this method to fail to decode
 	// into a RDF term is if the underlying stoarge has been corrupted on the file system level.
 	if len(b) == 0 {
@@ -889,21 +2338,16 @@ func (db *DB) decode(b []byte) (rdf.Term, error) {
 
 	var dt rdf.URI
 	switch b[0] {
-	case 0x00:
-		return rdf.URI(db.base + string(b[1:])), nil
-	case 0x01:
-		return rdf.URI(string(b[1:])), nil
+	case 0x00, 0x01, 0xFD:
+		return decodeURI(e.ns, e.base, b)
 	case 0x02:
 		return rdf.NewTypedLiteral(string(b[1:]), rdf.XSDstring), nil
 	case 0x03:
-		if len(b) < 2 {
-			return nil, fmt.Errorf("cannot decode as rdf:langString: %v", b)
-		}
-		ll := int(b[1])
-		if len(b) < ll+2 {
+		lang, val, ok := decodeTagged(b)
+		if !ok {
 			return nil, fmt.Errorf("cannot decode as rdf:langString: %v", b)
 		}
-		return rdf.NewLangLiteral(string(b[ll+2:]), string(b[2:2+ll])), nil
+		return rdf.NewLangLiteral(val, lang), nil
 	case 0x04:
 		dt = rdf.XSDboolean
 	case 0x05:
@@ -930,15 +2374,42 @@ func (db *DB) decode(b []byte) (rdf.Term, error) {
 		dt = rdf.XSDdouble
 	case 0x10:
 		dt = rdf.XSDdateTimeStamp
-	case 0xFF:
-		if len(b) < 2 {
-			return nil, fmt.Errorf("cannot decode as literal: %v", b)
+	case 0x11:
+		dt = rdf.XSDdate
+	case 0x12:
+		dt = rdf.XSDdateTime
+	case 0x13:
+		dt = rdf.XSDtime
+	case 0x14:
+		dt = rdf.XSDdecimal
+	case 0x15:
+		dt = rdf.XSDanyURI
+	case 0x16:
+		dt = rdf.XSDgYear
+	case 0x17:
+		dt = rdf.XSDgYearMonth
+	case 0x18:
+		dt = rdf.XSDduration
+	case 0x19:
+		dt = rdf.XSDnonNegativeInteger
+	case 0xFC:
+		id, n := binary.Uvarint(b[1:])
+		if n <= 0 {
+			return nil, fmt.Errorf("cannot decode as literal of registered datatype: %v", b)
+		}
+		dt, ok := e.dts.uris[uint32(id)]
+		if !ok {
+			return nil, fmt.Errorf("cannot decode literal of unknown datatype %d: %v", id, b)
 		}
-		ll := int(b[1])
-		if len(b) < ll {
+		return rdf.NewTypedLiteral(string(b[1+n:]), rdf.URI(dt)), nil
+	case 0xFE:
+		return rdf.BlankNode(string(b[1:])), nil
+	case 0xFF:
+		dt, val, ok := decodeTagged(b)
+		if !ok {
 			return nil, fmt.Errorf("cannot decode as literal: %v", b)
 		}
-		return rdf.NewTypedLiteral(string(b[ll+2:]), rdf.NewURI(string(b[2:2+ll]))), nil
+		return rdf.NewTypedLiteral(val, rdf.NewURI(dt)), nil
 	default:
 		return nil, fmt.Errorf("cannot decode RDF term: %v", b)
 	}
@@ -946,6 +2417,30 @@ func (db *DB) decode(b []byte) (rdf.Term, error) {
 	return rdf.NewTypedLiteral(string(b[1:]), dt), nil
 }
 
+// encodeTagged encodes a literal with the given tag, and the language tag
+// or datatype prefixed with its length:
+//
+//   tag | length of language tag/datatype (uvarint) | language tag/datatype | value
+func encodeTagged(tag byte, s string, val string) []byte {
+	b := make([]byte, 1+binary.MaxVarintLen64+len(s)+len(val))
+	b[0] = tag
+	n := 1 + binary.PutUvarint(b[1:], uint64(len(s)))
+	n += copy(b[n:], s)
+	n += copy(b[n:], val)
+	return b[:n]
+}
+
+// decodeTagged decodes the language tag or datatype, and the value of
+// a literal encoded by encodeTagged.
+func decodeTagged(b []byte) (s string, val string, ok bool) {
+	l, n := binary.Uvarint(b[1:])
+	if n <= 0 || uint64(len(b)-1-n) < l {
+		return "", "", false
+	}
+	start := 1 + n
+	return string(b[start : start+int(l)]), string(b[start+int(l):]), true
+}
+
 // u32tob converts a uint32 into a 4-byte slice.
 func u32tob(v uint32) []byte {
 	b := make([]byte, 4)
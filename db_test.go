@@ -2,13 +2,25 @@ package sopp
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"math/rand"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"testing/quick"
+	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/boutros/sopp/rdf"
 )
 
@@ -186,6 +198,184 @@ func TestEncodeDecode_Quick(t *testing.T) {
 	}
 }
 
+// Verify that literals with long datatypes and language tags survive an
+// encode and decode round trip, that malformed encodings are errors, and
+// that literals stored with single-byte lengths are migrated.
+func TestEncodeLongLiterals(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	long := strings.Repeat("x", 300)
+	lits := []rdf.Literal{
+		rdf.NewTypedLiteral("a", rdf.URI("http://test.org/"+long)),
+		rdf.NewTypedLiteral("b", rdf.URI("http://test.org/"+long[:200])),
+		rdf.NewLangLiteral("c", "en-"+long[:200]),
+	}
+	for _, lit := range lits {
+		b := db.encoding().encode(lit)
+		got, err := db.encoding().decode(b)
+		if err != nil || got != lit {
+			t.Errorf("decode(encode(%v)) => %v, %v", lit, got, err)
+		}
+	}
+	for _, b := range [][]byte{{0xFF}, {0xFF, 0x05, 'a'}, {0x03, 0x80}, {0x03, 0xAC, 0x02, 'a'}} {
+		if term, err := db.encoding().decode(b); err == nil {
+			t.Errorf("decode(%v) => %v; want error", b, term)
+		}
+	}
+
+	// Literals stored with the length of the datatype or language
+	// tag as a single byte are migrated.
+	tr := rdf.Triple{Subj: rdf.URI("http://test.org/s"), Pred: rdf.URI("http://test.org/p"), Obj: lits[1]}
+	tr2 := rdf.Triple{Subj: rdf.URI("http://test.org/s"), Pred: rdf.URI("http://test.org/p"), Obj: lits[2]}
+	for _, tr := range []rdf.Triple{tr, tr2} {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		for _, lit := range lits[1:] {
+			enc := db.encoding().encode(lit)
+			id, err := db.getIDb(tx, enc)
+			if err != nil {
+				return err
+			}
+			ll := len(lit.DataType())
+			if lit.DataType() == rdf.RDFlangString {
+				ll = len(lit.Lang())
+			}
+			old := append([]byte{enc[0], byte(ll)}, enc[3:]...)
+			if err := tx.Bucket(bucketIdxTerms).Delete(enc); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketIdxTerms).Put(old, u32tob(id)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketTerms).Put(u32tob(id), old); err != nil {
+				return err
+			}
+		}
+		return putUint64(tx.Bucket(bucketMeta), metaVersion, 2)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if db.DB, err = Open(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range []rdf.Triple{tr, tr2} {
+		if ok, err := db.Has(tr); err != nil || !ok {
+			t.Errorf("DB.Has(%v) after migration => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+	g, err := db.Describe(tr.Subj, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Size() != 2 || !g.Has(tr) || !g.Has(tr2) {
+		t.Errorf("DB.Describe(%v) after migration => %v", tr.Subj, g.Triples())
+	}
+}
+
+func TestEncodeXSDDatatypes(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	lits := []rdf.Literal{
+		rdf.NewTypedLiteral("2016-02-29", rdf.XSDdate),
+		rdf.NewTypedLiteral("2016-02-29T12:00:00+01:00", rdf.XSDdateTime),
+		rdf.NewTypedLiteral("12:00:00", rdf.XSDtime),
+		rdf.NewLiteral(big.NewRat(-314, 100)),
+		rdf.NewLiteral(rdf.URI("http://example.org/a")),
+		rdf.NewTypedLiteral("1999", rdf.XSDgYear),
+		rdf.NewTypedLiteral("1999-12", rdf.XSDgYearMonth),
+		rdf.NewLiteral(90 * time.Minute),
+		rdf.NewTypedLiteral("42", rdf.XSDnonNegativeInteger),
+	}
+	var triples []rdf.Triple
+	for i, lit := range lits {
+		b := db.encoding().encode(lit)
+		if b[0] == 0xFF || len(b) != len(lit.String())+1 {
+			t.Errorf("encode(%v) => %v; want a single byte datatype code", lit, b)
+		}
+		if got, err := db.encoding().decode(b); err != nil || got != lit {
+			t.Errorf("decode(encode(%v)) => %v, %v", lit, got, err)
+		}
+		triples = append(triples, rdf.Triple{
+			Subj: rdf.URI(fmt.Sprintf("http://test.org/s%d", i)),
+			Pred: rdf.URI("http://test.org/p"),
+			Obj:  lit,
+		})
+	}
+
+	// Literals of the datatypes stored with their datatype URI are migrated.
+	for _, tr := range triples {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		for _, lit := range lits {
+			enc := db.encoding().encode(lit)
+			id, err := db.getIDb(tx, enc)
+			if err != nil {
+				return err
+			}
+			old := encodeTagged(0xFF, string(lit.DataType()), lit.String())
+			if err := tx.Bucket(bucketIdxTerms).Delete(enc); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketIdxTerms).Put(old, u32tob(id)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketTerms).Put(u32tob(id), old); err != nil {
+				return err
+			}
+		}
+		return putUint64(tx.Bucket(bucketMeta), metaVersion, 3)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if db.DB, err = Open(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.kv.View(func(tx *bolt.Tx) error {
+		for _, lit := range lits {
+			if _, err := db.getIDb(tx, db.encoding().encode(lit)); err != nil {
+				return fmt.Errorf("%v after migration: %v", lit, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// The literals survive a dump and import into another database.
+	var b bytes.Buffer
+	if err := db.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	db2 := newTestDB()
+	defer db2.Close()
+	if _, err := db2.Import(&b, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range triples {
+		if ok, err := db2.Has(tr); err != nil || !ok {
+			t.Errorf("DB.Has(%v) after dump and import => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+}
+
 // Verify that Describe returns the same graph as rdf rdf.Graph reference implementation.
 func TestDescribe_Quick(t *testing.T) {
 	f := func(items testdata) bool {
@@ -287,3 +477,1823 @@ func TestImportDumpGraph_Quick(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// Verify that importing a graph in one batch stores the same triples, and
+// counts the same terms and triples, as inserting them one at a time.
+func TestImportGraphInsert_Quick(t *testing.T) {
+	f := func(items testdata) bool {
+		batched := newTestDB()
+		defer batched.Close()
+		single := newTestDB()
+		defer single.Close()
+
+		// Some triples are allready stored, and some are repeated.
+		items = append(items, items[:len(items)/2]...)
+		for _, item := range items[:len(items)/3] {
+			for _, db := range []*testDB{batched, single} {
+				if err := db.Insert(item.Triple); err != nil {
+					t.Logf("DB.Insert(%v) failed: %v", item.Triple, err)
+					t.FailNow()
+				}
+			}
+		}
+
+		if err := batched.ImportGraph(items.Graph()); err != nil {
+			t.Logf("DB.ImportGraph() failed: %v", err)
+			t.FailNow()
+		}
+		if err := batched.ImportGraph(items[:len(items)/2].Graph()); err != nil {
+			t.Logf("DB.ImportGraph() failed: %v", err)
+			t.FailNow()
+		}
+		for _, item := range items {
+			if err := single.Insert(item.Triple); err != nil {
+				t.Logf("DB.Insert(%v) failed: %v", item.Triple, err)
+				t.FailNow()
+			}
+		}
+
+		for _, item := range items {
+			if ok, err := batched.Has(item.Triple); err != nil || !ok {
+				t.Logf("DB.Has(%v) => %v, %v; want true, <nil>", item.Triple, ok, err)
+				t.FailNow()
+			}
+		}
+
+		var graphs [2]*rdf.Graph
+		var stats [2]Stats
+		for i, db := range []*testDB{batched, single} {
+			var b bytes.Buffer
+			if err := db.Dump(&b); err != nil {
+				t.Logf("DB.Dump() failed: %v", err)
+				t.FailNow()
+			}
+			g, err := rdf.NewDecoder(&b).DecodeGraph()
+			if err != nil {
+				t.Logf("Decoding dump of DB failed: %v", err)
+				t.FailNow()
+			}
+			graphs[i] = g
+			if stats[i], err = db.Stats(); err != nil {
+				t.Logf("DB.Stats() failed: %v", err)
+				t.FailNow()
+			}
+		}
+		if !graphs[0].Eq(graphs[1]) {
+			t.Log("Dump of imported graph not equal dump of inserted triples")
+			t.Log("got:")
+			t.Log(graphs[0].Triples())
+			t.Log("want:")
+			t.Log(graphs[1].Triples())
+			t.FailNow()
+		}
+		got, want := stats[0], stats[1]
+		if got.NumTerms != want.NumTerms || got.NumTriples != want.NumTriples ||
+			got.SPO.NumKeys != want.SPO.NumKeys || got.OSP.NumKeys != want.OSP.NumKeys || got.POS.NumKeys != want.POS.NumKeys {
+			t.Logf("DB.Stats() after import => %+v; after inserts %+v", got, want)
+			t.FailNow()
+		}
+
+		if err := batched.kv.Update(recount); err != nil {
+			t.Logf("recount failed: %v", err)
+			t.FailNow()
+		}
+		recounted, err := batched.Stats()
+		if err != nil {
+			t.Logf("DB.Stats() failed: %v", err)
+			t.FailNow()
+		}
+		if recounted != got {
+			t.Logf("DB.Stats() after import => %+v; recounted %+v", got, recounted)
+			t.FailNow()
+		}
+
+		print(".")
+		return true
+	}
+	if err := quick.Check(f, qconfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+// Verify that the parallel import stores the same graph as the items imported.
+func TestImportParallel_Quick(t *testing.T) {
+	f := func(items testdata) bool {
+		db := newTestDB()
+		defer db.Close()
+
+		RemoveDuplicates(&items)
+
+		input := items.Graph().Serialize(rdf.NTriples, "")
+		var last ImportProgress
+		n, err := db.ImportParallel(bytes.NewBufferString(input), 1+rand.Intn(10), 1+rand.Intn(4), func(p ImportProgress) {
+			if p.Triples <= last.Triples || p.Batches != last.Batches+1 {
+				t.Logf("progress %+v after %+v", p, last)
+				t.FailNow()
+			}
+			last = p
+		})
+		if err != nil {
+			t.Logf("DB.ImportParallel() failed: %v", err)
+			t.FailNow()
+		}
+		if n != len(items) || last.Triples != n {
+			t.Logf("DB.ImportParallel() => %d, last progress %+v; want %d", n, last, len(items))
+			t.FailNow()
+		}
+		if len(items) > 0 && last.Bytes != int64(len(input)) {
+			t.Logf("DB.ImportParallel() read %d bytes; want %d", last.Bytes, len(input))
+			t.FailNow()
+		}
+
+		want := items.Graph()
+		var b bytes.Buffer
+		if err = db.Dump(&b); err != nil {
+			t.Logf("DB.Dump() failed: %v", err)
+			t.FailNow()
+		}
+		got, err := rdf.NewDecoder(&b).DecodeGraph()
+		if err != nil {
+			t.Logf("Decoding dump of DB failed: %v", err)
+			t.FailNow()
+		}
+		if !got.Eq(want) {
+			t.Log("Dump of graph not equal imported graph")
+			t.Log("got:")
+			t.Log(got.Triples())
+			t.Log("want:")
+			t.Log(want.Triples())
+			t.FailNow()
+		}
+
+		print(".")
+		return true
+	}
+	if err := quick.Check(f, qconfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportOptions(t *testing.T) {
+	input := "<s1> <p> <o> .\n<s2> <p> .\n<s3> <p> <o> .\n<s1> <p> <o> .\n<s4> <p> <o> <x> .\n<s5> <p> <o> .\n"
+
+	tests := []struct {
+		opts    ImportOptions
+		want    ImportReport
+		wantErr error
+		errRows []int
+	}{
+		{ImportOptions{BatchSize: 10}, ImportReport{Read: 4, Stored: 3, Existing: 1, Rejected: 2}, nil, []int{2, 5}},
+		{ImportOptions{BatchSize: 10, MaxErrors: 2}, ImportReport{Read: 4, Stored: 3, Existing: 1, Rejected: 2}, nil, []int{2, 5}},
+		{ImportOptions{BatchSize: 10, MaxErrors: 1}, ImportReport{Read: 3, Rejected: 2}, ErrTooManyErrors, []int{2, 5}},
+		{ImportOptions{BatchSize: 1, MaxErrors: 1}, ImportReport{Read: 3, Stored: 2, Existing: 1, Rejected: 2}, ErrTooManyErrors, []int{2, 5}},
+		{ImportOptions{BatchSize: 1, Strict: true}, ImportReport{Read: 1, Stored: 1, Rejected: 1}, &rdf.DecodeError{}, []int{2}},
+	}
+
+	for _, test := range tests {
+		for _, workers := range []int{0, 2} {
+			db := newTestDB()
+
+			var rows []int
+			opts := test.opts
+			opts.Workers = workers
+			opts.OnError = func(err *rdf.DecodeError) {
+				rows = append(rows, err.Row)
+			}
+			rep, err := db.ImportWithOptions(bytes.NewBufferString(input), opts)
+			if test.wantErr == nil && err != nil {
+				t.Errorf("DB.ImportWithOptions(%+v) failed: %v", opts, err)
+			} else if _, ok := test.wantErr.(*rdf.DecodeError); ok {
+				if _, ok := err.(*rdf.DecodeError); !ok {
+					t.Errorf("DB.ImportWithOptions(%+v) => error %v; want *rdf.DecodeError", opts, err)
+				}
+			} else if err != test.wantErr {
+				t.Errorf("DB.ImportWithOptions(%+v) => error %v; want %v", opts, err, test.wantErr)
+			}
+			if rep != test.want {
+				t.Errorf("DB.ImportWithOptions(%+v) => %+v; want %+v", opts, rep, test.want)
+			}
+			if !reflect.DeepEqual(rows, test.errRows) {
+				t.Errorf("DB.ImportWithOptions(%+v) reported errors on lines %v; want %v", opts, rows, test.errRows)
+			}
+			if st, err := db.Stats(); err != nil || st.NumTriples != rep.Stored {
+				t.Errorf("DB.ImportWithOptions(%+v) stored %d triples; want %d", opts, st.NumTriples, rep.Stored)
+			}
+			db.Close()
+		}
+	}
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func TestResumeImport(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("@prefix ex: <http://example.org/> .\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&buf, "ex:s%d ex:p _:b%d ;\n\tex:q \"%d\" .\n", i, i%10, i)
+		if i == 20 {
+			buf.WriteString("@base <http://example.org/base/> .\n")
+		}
+		fmt.Fprintf(&buf, "_:b%d <r> <o%d> .\n", i%10, i)
+	}
+	input := buf.Bytes()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(input)
+	zw.Close()
+
+	// The resumed import can read from a seekable, unseekable or compressed input
+	readers := []func() io.Reader{
+		func() io.Reader { return bytes.NewReader(input) },
+		func() io.Reader { return bytes.NewBuffer(input) },
+		func() io.Reader { return bytes.NewReader(gz.Bytes()) },
+	}
+
+	for i, workers := range []int{0, 2, 0} {
+		newReader := readers[i]
+		want := newTestDB()
+		if _, err := want.ImportWithOptions(bytes.NewReader(input), ImportOptions{BatchSize: 7, Workers: workers}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Interrupt the import at various points after the first batch, and resume it
+		for _, n := range []int{200, 1000, 2000, len(input) - 10, len(input)} {
+			db := newTestDB()
+			opts := ImportOptions{BatchSize: 7, Workers: workers, Source: "test"}
+			interrupted := io.MultiReader(io.LimitReader(bytes.NewReader(input), int64(n)), iotest.ErrReader(errInterrupted))
+			if _, err := db.ImportWithOptions(interrupted, opts); err != errInterrupted {
+				t.Fatalf("import interrupted after %d bytes => %v; want %v", n, err, errInterrupted)
+			}
+			if cp, err := db.Checkpoint("test"); err == nil && cp.Offset > int64(n) {
+				t.Errorf("DB.Checkpoint() after import interrupted after %d bytes => offset %d", n, cp.Offset)
+			} else if err != nil && err != ErrNotFound {
+				t.Fatal(err)
+			}
+			opts.Resume = true
+			if _, err := db.ImportWithOptions(newReader(), opts); err != nil {
+				t.Fatalf("resuming import interrupted after %d bytes: %v", n, err)
+			}
+
+			var got, wantDump bytes.Buffer
+			if err := db.Dump(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := want.Dump(&wantDump); err != nil {
+				t.Fatal(err)
+			}
+			gotG, err := rdf.NewDecoder(&got).DecodeGraph()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantG, err := rdf.NewDecoder(&wantDump).DecodeGraph()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !gotG.Eq(wantG) {
+				t.Errorf("resuming import interrupted after %d bytes (%d workers): got:\n%v\nwant:\n%v",
+					n, workers, gotG.Serialize(rdf.NTriples, ""), wantG.Serialize(rdf.NTriples, ""))
+			}
+
+			// There is nothing left to resume
+			if cp, err := db.Checkpoint("test"); err != ErrNotFound {
+				t.Errorf("DB.Checkpoint() after completed import => %+v, %v; want %v", cp, err, ErrNotFound)
+			}
+			db.Close()
+		}
+		want.Close()
+	}
+}
+
+func TestImportCompressed(t *testing.T) {
+	input := "<http://test.org/s> <http://test.org/p> <http://test.org/o1> .\n<http://test.org/s> <http://test.org/p> <http://test.org/o2> .\n"
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(input))
+	zw.Close()
+	// bzip2 compressed input, the standard library has no bzip2 writer
+	bz := "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xd5\xf9\xbc\xab\x00\x00\x17\x59\x80\x00\x10\x40\x01\xb0\x15\x02\xc0\xdc\x00\x20\x00\x50\xa1\xa6\x98\x00\x11\x54\xd3\xd4\x64\xda\x99\x3d\x4b\x18\x1e\x4a\x1c\x0c\x8d\xc7\x46\xc7\xb0\xf0\x99\x82\x8c\x8d\x0e\xc7\x26\xc6\x06\xc4\x8f\x07\xc3\x71\xb0\xc0\xd0\xfe\x2e\xe4\x8a\x70\xa1\x21\xab\xf3\x79\x56"
+
+	for _, test := range []struct {
+		format string
+		input  []byte
+	}{
+		{"plain", []byte(input)},
+		{"gzip", gz.Bytes()},
+		{"bzip2", []byte(bz)},
+	} {
+		db := newTestDB()
+		n, err := db.Import(bytes.NewReader(test.input), 10)
+		if err != nil || n != 2 {
+			t.Errorf("DB.Import(%s) => %d, %v; want 2, nil", test.format, n, err)
+		}
+		for _, o := range []string{"o1", "o2"} {
+			tr := rdf.Triple{Subj: rdf.NewURI("http://test.org/s"), Pred: rdf.NewURI("http://test.org/p"), Obj: rdf.NewURI("http://test.org/" + o)}
+			if ok, err := db.Has(tr); err != nil || !ok {
+				t.Errorf("DB.Has(%v) after DB.Import(%s) => %v, %v; want true, nil", tr, test.format, ok, err)
+			}
+		}
+		db.Close()
+	}
+}
+
+// Verify that an import of truncated compressed input fails, instead of
+// storing the triples read as if the input was complete.
+func TestImportTruncated(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "<http://test.org/s%d> <http://test.org/p> \"%d\" .\n", i, i)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(input.Bytes())
+	zw.Close()
+	truncated := gz.Bytes()[:gz.Len()/2]
+
+	for _, opts := range []ImportOptions{
+		{BatchSize: 100},
+		{BatchSize: 100, Workers: 2},
+		{BatchSize: 100, Workers: 2, Strict: true},
+	} {
+		db := newTestDB()
+		rep, err := db.ImportWithOptions(bytes.NewReader(truncated), opts)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("DB.ImportWithOptions(%+v) of truncated gzip => %+v, %v; want %v", opts, rep, err, io.ErrUnexpectedEOF)
+		}
+		if rep.Rejected != 0 {
+			t.Errorf("DB.ImportWithOptions(%+v) of truncated gzip rejected %d triples; want 0", opts, rep.Rejected)
+		}
+		db.Close()
+	}
+}
+
+// Verify that Construct returns the same graph as rdf.Graph reference implementation.
+func TestConstruct_Quick(t *testing.T) {
+	f := func(items testdata) bool {
+		db := newTestDB()
+		defer db.Close()
+
+		// test against in-memory reference implementation
+		ref := rdf.NewGraph()
+
+		for _, item := range items {
+			if err := db.Insert(item.Triple); err != nil {
+				t.Logf("DB.Insert(%v) failed: %v", item.Triple, err)
+				t.FailNow()
+			}
+			ref.Insert(item.Triple)
+		}
+
+		for _, item := range items {
+			tr := item.Triple
+			patterns := []rdf.Pattern{
+				{Subj: rdf.Any, Pred: rdf.Any, Obj: rdf.Any},
+				{Subj: tr.Subj, Pred: rdf.Any, Obj: rdf.Any},
+				{Subj: rdf.Any, Pred: tr.Pred, Obj: rdf.Any},
+				{Subj: rdf.Any, Pred: rdf.Any, Obj: tr.Obj.(rdf.QVar)},
+				{Subj: tr.Subj, Pred: tr.Pred, Obj: rdf.Any},
+				{Subj: tr.Subj, Pred: rdf.Any, Obj: tr.Obj.(rdf.QVar)},
+				{Subj: rdf.Any, Pred: tr.Pred, Obj: tr.Obj.(rdf.QVar)},
+				{Subj: tr.Subj, Pred: tr.Pred, Obj: tr.Obj.(rdf.QVar)},
+				{Subj: rdf.URI("http://test.org/missing"), Pred: rdf.Any, Obj: rdf.Any},
+			}
+			for _, p := range patterns {
+				want := ref.Construct(p)
+				got, err := db.Construct(p)
+				if err != nil {
+					t.Logf("DB.Construct(%v) failed: %v", p, err)
+					t.FailNow()
+				}
+				if !got.Eq(want) {
+					t.Logf("DB.Construct(%v) =>\n%v\nwant:\n%v", p, got.Triples(), want.Triples())
+					t.FailNow()
+				}
+			}
+		}
+
+		print(".")
+		return true
+	}
+	if err := quick.Check(f, qconfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+// Verify that triples in named graphs are kept apart from the default graph and
+// each other, and that dropping a graph removes the terms only used in it.
+func TestNamedGraphs_Quick(t *testing.T) {
+	f := func(items testdata) bool {
+		db := newTestDB()
+		defer db.Close()
+
+		names := []rdf.URI{"", rdf.URI("http://test.org/g1"), rdf.URI("http://test.org/g2")}
+		ref := make(map[rdf.URI]*rdf.Graph)
+		for _, name := range names {
+			ref[name] = rdf.NewGraph()
+		}
+
+		for i, item := range items {
+			name := names[i%len(names)]
+			var err error
+			if name == "" {
+				err = db.Insert(item.Triple)
+			} else {
+				err = db.InsertIn(name, item.Triple)
+			}
+			if err != nil {
+				t.Logf("inserting %v in graph %q failed: %v", item.Triple, name, err)
+				t.FailNow()
+			}
+			ref[name].Insert(item.Triple)
+		}
+
+		for _, item := range items {
+			for _, name := range names[1:] {
+				want := ref[name].Has(item.Triple)
+				got, err := db.HasIn(name, item.Triple)
+				if err != nil {
+					t.Logf("DB.HasIn(%v, %v) failed: %v", name, item.Triple, err)
+					t.FailNow()
+				}
+				if got != want {
+					t.Logf("DB.HasIn(%v, %v) => %v; want %v", name, item.Triple, got, want)
+					t.FailNow()
+				}
+
+				g, err := db.DescribeIn(name, item.Triple.Subj, true)
+				if err != nil {
+					t.Logf("DB.DescribeIn(%v, %v, true) failed: %v", name, item.Triple.Subj, err)
+					t.FailNow()
+				}
+				if wantG := ref[name].Describe(item.Triple.Subj, true); !g.Eq(wantG) {
+					t.Logf("DB.DescribeIn(%v, %v, true) =>\n%s\nwant:\n%s",
+						name, item.Triple.Subj, g.Serialize(rdf.Turtle, ""), wantG.Serialize(rdf.Turtle, ""))
+					t.FailNow()
+				}
+			}
+			got, err := db.Describe(item.Triple.Subj, true)
+			if err != nil {
+				t.Logf("DB.Describe(%v, true) failed: %v", item.Triple.Subj, err)
+				t.FailNow()
+			}
+			if want := ref[""].Describe(item.Triple.Subj, true); !got.Eq(want) {
+				t.Logf("DB.Describe(%v, true) =>\n%s\nwant:\n%s",
+					item.Triple.Subj, got.Serialize(rdf.Turtle, ""), want.Serialize(rdf.Turtle, ""))
+				t.FailNow()
+			}
+		}
+
+		graphs, err := db.Graphs()
+		if err != nil {
+			t.Logf("DB.Graphs() failed: %v", err)
+			t.FailNow()
+		}
+		if len(items) >= len(names) && !reflect.DeepEqual(graphs, names[1:]) {
+			t.Logf("DB.Graphs() => %v; want %v", graphs, names[1:])
+			t.FailNow()
+		}
+
+		if len(items) < 2 {
+			// No triples were inserted in names[1], so it was never created.
+			print(".")
+			return true
+		}
+		if err := db.DropGraph(names[1]); err != nil {
+			t.Logf("DB.DropGraph(%v) failed: %v", names[1], err)
+			t.FailNow()
+		}
+		if err := db.DropGraph(names[1]); err != ErrNotFound {
+			t.Logf("DB.DropGraph(%v) on dropped graph => %v; want ErrNotFound", names[1], err)
+			t.FailNow()
+		}
+
+		// The remaining terms should be exactly those in the remaining graphs.
+		terms := make(map[rdf.Term]bool)
+		for _, name := range []rdf.URI{"", names[2]} {
+			for _, tr := range ref[name].Triples() {
+				terms[tr.Subj] = true
+				terms[tr.Pred] = true
+				terms[tr.Obj] = true
+			}
+			if name != "" && ref[name].Size() > 0 {
+				terms[name] = true
+			}
+		}
+		st, err := db.Stats()
+		if err != nil {
+			t.Logf("DB.Stats() failed: %v", err)
+			t.FailNow()
+		}
+		if st.NumTerms != len(terms) {
+			t.Logf("after DB.DropGraph(%v): got %d terms; want %d", names[1], st.NumTerms, len(terms))
+			t.FailNow()
+		}
+		for _, tr := range ref[names[1]].Triples() {
+			if ok, _ := db.HasIn(names[1], tr); ok {
+				t.Logf("DB.HasIn(%v, %v) => true after DropGraph", names[1], tr)
+				t.FailNow()
+			}
+		}
+
+		print(".")
+		return true
+	}
+	if err := quick.Check(f, qconfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlankNodes(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	// Blank nodes inserted directly are stored as given.
+	tr := rdf.Triple{Subj: rdf.BlankNode("x"), Pred: rdf.URI("http://test.org/p"), Obj: rdf.BlankNode("y")}
+	if err := db.Insert(tr); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has(tr); err != nil || !ok {
+		t.Fatalf("DB.Has(%v) => %v, %v; want true, nil", tr, ok, err)
+	}
+
+	// Blank nodes with the same label in different imports are different nodes.
+	for _, name := range []string{"a", "b"} {
+		input := `_:n <http://test.org/name> "` + name + `" .`
+		if _, err := db.Import(bytes.NewBufferString(input), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := db.Query(rdf.Pattern{Subj: rdf.Variable("n"), Pred: rdf.URI("http://test.org/name"), Obj: rdf.Any})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("got %d blank nodes with name; want 2", len(res))
+	}
+	if res[0]["n"] == res[1]["n"] {
+		t.Errorf("blank nodes from different imports collide: %v", res[0]["n"])
+	}
+	for _, r := range res {
+		if _, ok := r["n"].(rdf.BlankNode); !ok {
+			t.Errorf("got %v; want a blank node", r["n"])
+		}
+	}
+
+	// Blank nodes with the same label within an import are the same node,
+	// even if the import is split in several batches.
+	input := `_:m <http://test.org/p> "1" .
+_:m <http://test.org/p> "2" .
+_:m <http://test.org/p> "3" .`
+	if _, err := db.Import(bytes.NewBufferString(input), 1); err != nil {
+		t.Fatal(err)
+	}
+	res, err = db.Query(rdf.Pattern{Subj: rdf.Variable("m"), Pred: rdf.URI("http://test.org/p"), Obj: rdf.Any})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := make(map[rdf.Term]bool)
+	for _, r := range res {
+		nodes[r["m"]] = true
+	}
+	if len(res) != 4 || len(nodes) != 2 {
+		t.Errorf("got %d triples with %d subjects; want 4 triples with 2 subjects", len(res), len(nodes))
+	}
+
+	// The dump, with the scoped labels of the blank nodes, can be imported again.
+	var dump bytes.Buffer
+	if err := db.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	want, err := rdf.NewDecoder(bytes.NewReader(dump.Bytes())).DecodeGraph()
+	if err != nil {
+		t.Fatalf("decoding dump: %v", err)
+	}
+	other := newTestDB()
+	defer other.Close()
+	if n, err := other.Import(bytes.NewReader(dump.Bytes()), 10); err != nil || n != want.Size() {
+		t.Fatalf("importing dump => %d, %v; want %d, <nil>", n, err, want.Size())
+	}
+	blanks := func(g *rdf.Graph) int {
+		nodes := make(map[rdf.Term]bool)
+		for _, tr := range g.Triples() {
+			for _, t := range []rdf.Term{tr.Subj, tr.Obj} {
+				if _, ok := t.(rdf.BlankNode); ok {
+					nodes[t] = true
+				}
+			}
+		}
+		return len(nodes)
+	}
+	dump.Reset()
+	if err := other.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rdf.NewDecoder(&dump).DecodeGraph()
+	if err != nil {
+		t.Fatalf("decoding dump of imported dump: %v", err)
+	}
+	if got.Size() != want.Size() || blanks(got) != blanks(want) {
+		t.Errorf("dump of imported dump has %d triples and %d blank nodes; want %d and %d",
+			got.Size(), blanks(got), want.Size(), blanks(want))
+	}
+}
+
+// Verify that the predicate cache is consistent with the stored terms, also
+// after rolled back transactions, removals, and reopening the database.
+func TestPredicateCache(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	p1 := rdf.URI("http://test.org/p1")
+	p2 := rdf.URI("http://test.org/p2")
+	tr1 := rdf.Triple{Subj: rdf.URI("http://test.org/s"), Pred: p1, Obj: rdf.NewLiteral("o")}
+	tr2 := rdf.Triple{Subj: rdf.URI("http://test.org/s"), Pred: p2, Obj: rdf.NewLiteral("o")}
+
+	cached := func(pred rdf.URI) bool {
+		_, ok := db.pred.GetID(pred)
+		return ok
+	}
+
+	// A predicate inserted in a transaction which is rolled back is not cached.
+	if err := db.SparqlUpdate(`INSERT DATA { <http://test.org/s> <http://test.org/p1> "o" } ;
+		INSERT DATA { "x" <http://test.org/p> "o" }`); err == nil {
+		t.Fatal("invalid update succeeded")
+	}
+	if cached(p1) {
+		t.Fatalf("predicate %v cached after rollback", p1)
+	}
+
+	for _, tr := range []rdf.Triple{tr1, tr2} {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !cached(p1) || !cached(p2) {
+		t.Fatal("predicates not cached after insert")
+	}
+
+	// An orphaned predicate is removed from the cache
+	if err := db.Delete(tr2); err != nil {
+		t.Fatal(err)
+	}
+	if cached(p2) {
+		t.Fatalf("predicate %v cached after removal", p2)
+	}
+
+	// A predicate inserted and removed in the same transaction is not cached.
+	if err := db.SparqlUpdate(`INSERT DATA { <http://test.org/s> <http://test.org/p2> "o" } ;
+		DELETE DATA { <http://test.org/s> <http://test.org/p2> "o" }`); err != nil {
+		t.Fatal(err)
+	}
+	if cached(p2) {
+		t.Fatalf("predicate %v cached after insert and removal", p2)
+	}
+
+	// The cache is loaded when opening the database.
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path, "http://test.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = reopened
+	if !cached(p1) || cached(p2) || db.pred.Size() != 1 {
+		t.Errorf("got %d predicates cached after reopening; want only %v", db.pred.Size(), p1)
+	}
+	g, err := db.Describe(tr1.Subj, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Has(tr1) || g.Size() != 1 {
+		t.Errorf("DB.Describe(%v) => %v; want %v", tr1.Subj, g.Triples(), tr1)
+	}
+}
+
+// Verify that the counters kept when inserting and removing triples
+// are the same as counted by scanning the indices.
+func TestStats_Quick(t *testing.T) {
+	f := func(items testdata) bool {
+		db := newTestDB()
+		defer db.Close()
+
+		RemoveDuplicates(&items)
+
+		g := rdf.URI("http://test.org/graph")
+		for i, item := range items {
+			var err error
+			if i%2 == 0 {
+				err = db.Insert(item.Triple)
+			} else {
+				err = db.InsertIn(g, item.Triple)
+			}
+			if err != nil {
+				t.Logf("inserting %v failed: %v", item.Triple, err)
+				t.FailNow()
+			}
+		}
+
+		check := func(wantTriples int) {
+			got, err := db.Stats()
+			if err != nil {
+				t.Logf("DB.Stats() failed: %v", err)
+				t.FailNow()
+			}
+			if got.NumTriples != wantTriples {
+				t.Logf("DB.Stats().NumTriples => %d; want %d", got.NumTriples, wantTriples)
+				t.FailNow()
+			}
+			if err := db.kv.Update(recount); err != nil {
+				t.Logf("recount failed: %v", err)
+				t.FailNow()
+			}
+			want, err := db.Stats()
+			if err != nil {
+				t.Logf("DB.Stats() failed: %v", err)
+				t.FailNow()
+			}
+			if got != want {
+				t.Logf("DB.Stats() => %+v; recounted %+v", got, want)
+				t.FailNow()
+			}
+		}
+
+		check(len(items))
+
+		for i := 0; i < len(items); i += 4 {
+			if err := db.Delete(items[i].Triple); err != nil {
+				t.Logf("DB.Delete(%v) failed: %v", items[i].Triple, err)
+				t.FailNow()
+			}
+		}
+		check(len(items) - (len(items)+3)/4)
+
+		if len(items) > 1 {
+			if err := db.DropGraph(g); err != nil {
+				t.Logf("DB.DropGraph(%v) failed: %v", g, err)
+				t.FailNow()
+			}
+			check((len(items)+1)/2 - (len(items)+3)/4)
+		}
+
+		print(".")
+		return true
+	}
+	if err := quick.Check(f, qconfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+// Verify that the IDs of deleted terms are reused.
+func TestReclaimTermIDs(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	sequence := func() (n uint64) {
+		db.kv.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket(bucketTerms).Sequence()
+			return nil
+		})
+		return n
+	}
+
+	for i := 0; i < 10; i++ {
+		tr := rdf.Triple{
+			Subj: rdf.URI("http://test.org/s"),
+			Pred: rdf.URI("http://test.org/p"),
+			Obj:  rdf.NewLiteral(i),
+		}
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := sequence(); n != 3 {
+		t.Errorf("term ID sequence => %d after inserting and deleting; want 3", n)
+	}
+
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumTerms != 0 {
+		t.Errorf("got %d terms stored; want 0", st.NumTerms)
+	}
+}
+
+// viewBefore opens a read-only transaction, calls write, and then calls read
+// with the transaction, which must see the database as it was before write.
+// The file is grown first, so that write does not have to remap it, which
+// would wait for the transaction to be closed.
+func viewBefore(t *testing.T, db *testDB, write func() error, read func(tx *Tx)) {
+	t.Helper()
+	scratch := []byte("scratch")
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket(scratch)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(scratch, make([]byte, 1<<20))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(scratch)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.kv.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := write(); err != nil {
+		t.Fatal(err)
+	}
+	read(&Tx{db: db.DB, tx: tx})
+}
+
+// Verify that a transaction does not see the terms stored with the IDs of
+// deleted terms after it started.
+func TestReuseIDSnapshot(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s, o := rdf.URI("http://test.org/s"), rdf.URI("http://test.org/o")
+	p, q := rdf.URI("http://test.org/P"), rdf.URI("http://test.org/Q")
+	tr := rdf.Triple{Subj: s, Pred: p, Obj: o}
+	if err := db.Insert(tr); err != nil {
+		t.Fatal(err)
+	}
+
+	viewBefore(t, db, func() error {
+		if err := db.Delete(tr); err != nil {
+			return err
+		}
+		// The IDs of s, P and o are reused for o, Q and s.
+		return db.Insert(rdf.Triple{Subj: o, Pred: q, Obj: s})
+	}, func(tx *Tx) {
+		want := rdf.NewGraph()
+		want.Insert(tr)
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), want.Triples())
+		}
+		if ok, err := tx.Has(rdf.Triple{Subj: s, Pred: q, Obj: o}); err != nil || ok {
+			t.Errorf("Tx.Has(%v %v %v) => %v, %v; want false, <nil>", s, q, o, ok, err)
+		}
+		if ok, err := tx.Has(tr); err != nil || !ok {
+			t.Errorf("Tx.Has(%v) => %v, %v; want true, <nil>", tr, ok, err)
+		}
+	})
+}
+
+// Verify that a transaction does not see the new URI of a term renamed
+// after it started.
+func TestRenameURISnapshot(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s, o := rdf.URI("http://test.org/s"), rdf.URI("http://test.org/o")
+	p, q := rdf.URI("http://test.org/P"), rdf.URI("http://test.org/Q")
+	tr := rdf.Triple{Subj: s, Pred: p, Obj: o}
+	if err := db.Insert(tr); err != nil {
+		t.Fatal(err)
+	}
+
+	viewBefore(t, db, func() error {
+		if err := db.RenameURI(p, q); err != nil {
+			return err
+		}
+		// Using the renamed predicate caches it under its new URI.
+		return db.Insert(rdf.Triple{Subj: o, Pred: q, Obj: s})
+	}, func(tx *Tx) {
+		want := rdf.NewGraph()
+		want.Insert(tr)
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), want.Triples())
+		}
+		if ok, err := tx.Has(rdf.Triple{Subj: s, Pred: q, Obj: o}); err != nil || ok {
+			t.Errorf("Tx.Has(%v %v %v) => %v, %v; want false, <nil>", s, q, o, ok, err)
+		}
+		if ok, err := tx.Has(tr); err != nil || !ok {
+			t.Errorf("Tx.Has(%v) => %v, %v; want true, <nil>", tr, ok, err)
+		}
+	})
+}
+
+func TestTx(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s := rdf.URI("http://test.org/s")
+	p := rdf.URI("http://test.org/p")
+	tr1 := rdf.Triple{Subj: s, Pred: p, Obj: rdf.NewLiteral("1")}
+	tr2 := rdf.Triple{Subj: s, Pred: p, Obj: rdf.NewLiteral("2")}
+	tr3 := rdf.Triple{Subj: s, Pred: p, Obj: rdf.NewLiteral("3")}
+
+	// Nothing is stored if the transaction fails
+	errAbort := errors.New("abort")
+	if err := db.Update(func(tx *Tx) error {
+		if err := tx.Insert(tr1); err != nil {
+			return err
+		}
+		if ok, err := tx.Has(tr1); err != nil || !ok {
+			t.Errorf("Tx.Has(%v) after Tx.Insert => %v, %v; want true, nil", tr1, ok, err)
+		}
+		return errAbort
+	}); err != errAbort {
+		t.Fatalf("DB.Update => %v; want %v", err, errAbort)
+	}
+	if ok, err := db.Has(tr1); err != nil || ok {
+		t.Fatalf("DB.Has(%v) after rolled back insert => %v, %v; want false, nil", tr1, ok, err)
+	}
+
+	if err := db.Update(func(tx *Tx) error {
+		for _, tr := range []rdf.Triple{tr1, tr2} {
+			if err := tx.Insert(tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the description of a resource
+	if err := db.Update(func(tx *Tx) error {
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			return err
+		}
+		for _, tr := range g.Triples() {
+			if err := tx.Delete(tr); err != nil {
+				return err
+			}
+		}
+		return tx.Insert(tr3)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read a consistent snapshot
+	if err := db.View(func(tx *Tx) error {
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			return err
+		}
+		if g.Size() != 1 || !g.Has(tr3) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), tr3)
+		}
+		n := 0
+		if err := tx.Match(rdf.Pattern{Subj: s, Pred: rdf.Any, Obj: rdf.Any}, func(rdf.Triple) error {
+			n++
+			return nil
+		}); err != nil {
+			return err
+		}
+		if n != 1 {
+			t.Errorf("Tx.Match => %d triples; want 1", n)
+		}
+		if err := tx.Insert(tr1); err != bolt.ErrTxNotWritable {
+			t.Errorf("Tx.Insert in read-only transaction => %v; want %v", err, bolt.ErrTxNotWritable)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteMatching(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	input := `@base <http://test.org/> .
+<a> <knows> <b> .
+<a> <knows> <c> .
+<a> <name> "a" .
+<b> <knows> <a> .
+<b> <name> "b" .
+<c> <knows> <a> .
+<c> <name> "c" .
+<a> <self> <a> .`
+	if _, err := db.Import(bytes.NewBufferString(input), 10); err != nil {
+		t.Fatal(err)
+	}
+	uri := func(s string) rdf.URI { return rdf.URI("http://test.org/" + s) }
+	numTerms := func() int {
+		st, err := db.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.NumTerms
+	}
+
+	tests := []struct {
+		delete    func() (int, error)
+		n         int
+		remaining int // triples
+		terms     int
+	}{
+		{
+			func() (int, error) {
+				return db.DeleteMatching(rdf.Pattern{Subj: uri("x"), Pred: rdf.Any, Obj: rdf.Any})
+			},
+			0, 8, 9,
+		},
+		{
+			func() (int, error) {
+				return db.DeleteMatching(rdf.Pattern{Subj: rdf.Any, Pred: uri("name"), Obj: rdf.NewLiteral("b")})
+			},
+			1, 7, 8,
+		},
+		{
+			func() (int, error) {
+				return db.DeleteResource(uri("c"), false)
+			},
+			2, 5, 7,
+		},
+		{
+			func() (int, error) {
+				return db.DeleteResource(uri("a"), true)
+			},
+			5, 0, 0,
+		},
+	}
+	for i, test := range tests {
+		n, err := test.delete()
+		if err != nil || n != test.n {
+			t.Errorf("%d: got %d, %v; want %d, nil", i, n, err, test.n)
+		}
+		g, err := db.Construct(rdf.Pattern{Subj: rdf.Any, Pred: rdf.Any, Obj: rdf.Any})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Size() != test.remaining {
+			t.Errorf("%d: %d triples remaining; want %d", i, g.Size(), test.remaining)
+		}
+		if n := numTerms(); n != test.terms {
+			t.Errorf("%d: %d terms stored; want %d", i, n, test.terms)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s := rdf.URI("http://test.org/s")
+	p := rdf.URI("http://test.org/p")
+	tr := func(o string) rdf.Triple {
+		return rdf.Triple{Subj: s, Pred: p, Obj: rdf.NewLiteral(o)}
+	}
+	for _, o := range []string{"a", "b"} {
+		if err := db.Insert(tr(o)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := rdf.Triple{Subj: rdf.URI("http://test.org/x"), Pred: p, Obj: s}
+	if err := db.Insert(other); err != nil {
+		t.Fatal(err)
+	}
+
+	g := rdf.NewGraph()
+	g.Insert(tr("b"), tr("c"))
+	diff, err := db.Replace(s, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Removed.Size() != 1 || !diff.Removed.Has(tr("a")) {
+		t.Errorf("DB.Replace removed %v; want %v", diff.Removed.Triples(), tr("a"))
+	}
+	if diff.Added.Size() != 1 || !diff.Added.Has(tr("c")) {
+		t.Errorf("DB.Replace added %v; want %v", diff.Added.Triples(), tr("c"))
+	}
+	got, err := db.Describe(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Eq(g) {
+		t.Errorf("DB.Describe(%v) after DB.Replace => %v; want %v", s, got.Triples(), g.Triples())
+	}
+	// Triples where the node is object are kept
+	if ok, err := db.Has(other); err != nil || !ok {
+		t.Errorf("DB.Has(%v) after DB.Replace => %v, %v; want true, nil", other, ok, err)
+	}
+
+	// Nothing is changed if the graph describes other resources
+	g.Insert(other)
+	if _, err := db.Replace(s, g); err == nil {
+		t.Errorf("DB.Replace with triple about another resource succeeded")
+	}
+	g.Delete(other)
+	diff, err = db.Replace(s, g)
+	if err != nil || diff.Removed.Size() != 0 || diff.Added.Size() != 0 {
+		t.Errorf("DB.Replace with same description => %v, %v, %v; want empty diff", diff.Removed.Triples(), diff.Added.Triples(), err)
+	}
+}
+
+func TestRenameMergeURIs(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	input := `@base <http://test.org/> .
+<a> <p> <x> .
+<b> <p> <x> .
+<b> <p> <b> .
+<x> <q> <b> .
+<x> <old> "1" .`
+	if _, err := db.Import(bytes.NewBufferString(input), 10); err != nil {
+		t.Fatal(err)
+	}
+	uri := func(s string) rdf.URI { return rdf.URI("http://test.org/" + s) }
+	if err := db.InsertIn(uri("b"), rdf.Triple{Subj: uri("b"), Pred: uri("p"), Obj: uri("y")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertIn(uri("a"), rdf.Triple{Subj: uri("a"), Pred: uri("p"), Obj: uri("z")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming a predicate to a new URI
+	if err := db.RenameURI(uri("old"), uri("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RenameURI(uri("old"), uri("new")); err != ErrNotFound {
+		t.Errorf("DB.RenameURI of URI not stored => %v; want ErrNotFound", err)
+	}
+
+	// Merging two resources, also used as graph names
+	if err := db.MergeURIs(uri("a"), uri("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<http://test.org/a> <http://test.org/p> <http://test.org/x>, <http://test.org/a> .
+<http://test.org/x> <http://test.org/q> <http://test.org/a> ;
+	<http://test.org/new> "1" .
+`
+	g, err := db.Construct(rdf.Pattern{Subj: rdf.Any, Pred: rdf.Any, Obj: rdf.Any})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantGraph, err := rdf.NewDecoder(bytes.NewBufferString(want)).DecodeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Eq(wantGraph) {
+		t.Errorf("after merge got:\n%v\nwant:\n%v", g.Serialize(rdf.Turtle, ""), want)
+	}
+	if graphs, err := db.Graphs(); err != nil || len(graphs) != 1 || graphs[0] != uri("a") {
+		t.Errorf("DB.Graphs() after merge => %v, %v; want [%v]", graphs, err, uri("a"))
+	}
+	g, err = db.DescribeIn(uri("a"), uri("a"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Size() != 2 {
+		t.Errorf("DB.DescribeIn(%v) after merge => %v; want 2 triples", uri("a"), g.Triples())
+	}
+	if _, err := db.Describe(uri("b"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The counters are kept
+	got, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.kv.Update(recount); err != nil {
+		t.Fatal(err)
+	}
+	recounted, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != recounted {
+		t.Errorf("DB.Stats() => %+v; recounted %+v", got, recounted)
+	}
+	if got.NumTriples != 6 || got.NumTerms != 8 {
+		t.Errorf("got %d triples and %d terms; want 6 and 8", got.NumTriples, got.NumTerms)
+	}
+}
+
+func TestOpenMetadata(t *testing.T) {
+	db := newTestDB()
+	path := db.kv.Path()
+	defer os.Remove(path)
+	defer func() {
+		if db.DB != nil {
+			db.DB.Close()
+		}
+	}()
+
+	tr := rdf.Triple{Subj: rdf.URI("http://test.org/s"), Pred: rdf.URI("http://test.org/p"), Obj: rdf.URI("http://test.org/o")}
+	if err := db.Insert(tr); err != nil {
+		t.Fatal(err)
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Version != int(formatVersion) || st.Base != "http://test.org/" || time.Since(st.Created) > time.Minute {
+		t.Errorf("DB.Stats() of new database => version %d, base %q, created %v", st.Version, st.Base, st.Created)
+	}
+
+	reopen := func(base string) (err error) {
+		if db.DB != nil {
+			if err := db.DB.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.DB, err = Open(path, base)
+		return err
+	}
+
+	err = reopen("http://example.org/")
+	if e, ok := err.(*BaseError); !ok || e.Base != "http://example.org/" || e.Stored != "http://test.org/" {
+		t.Errorf("Open with another base URI => %v; want *BaseError", err)
+	}
+	if err := reopen(""); err != nil {
+		t.Fatalf("Open without base URI => %v", err)
+	}
+	if ok, err := db.Has(tr); err != nil || !ok {
+		t.Errorf("DB.Has(%v) after reopening => %v, %v; want true, nil", tr, ok, err)
+	}
+
+	// A database created before the format was versioned is migrated
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		for _, key := range [][]byte{metaVersion, metaBase, metaCreated, metaNumTriples} {
+			if err := tx.Bucket(bucketMeta).Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopen("http://test.org/"); err != nil {
+		t.Fatalf("Open of unversioned database => %v", err)
+	}
+	st, err = db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Version != int(formatVersion) || st.Base != "http://test.org/" || !st.Created.IsZero() || st.NumTriples != 1 {
+		t.Errorf("DB.Stats() of migrated database => version %d, base %q, created %v, %d triples", st.Version, st.Base, st.Created, st.NumTriples)
+	}
+
+	// A database of a newer version is not opened
+	if err := db.kv.Update(func(tx *bolt.Tx) error {
+		return putUint64(tx.Bucket(bucketMeta), metaVersion, formatVersion+1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopen(""); err == nil {
+		t.Errorf("Open of database with newer format version succeeded")
+	}
+}
+
+func TestChangeBase(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	// The relative part of the URIs is the same with both bases
+	trs := []rdf.Triple{
+		{Subj: rdf.URI("http://test.org/foo"), Pred: rdf.URI("http://test.org/p"), Obj: rdf.URI("http://example.org/foo")},
+		{Subj: rdf.URI("http://example.org/foo"), Pred: rdf.URI("http://example.org/p"), Obj: rdf.NewLiteral("foo")},
+		{Subj: rdf.BlankNode("foo"), Pred: rdf.URI("http://other.org/p"), Obj: rdf.URI("http://test.org/p")},
+	}
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func() map[rdf.Term]uint32 {
+		res := make(map[rdf.Term]uint32)
+		if err := db.kv.View(func(tx *bolt.Tx) error {
+			for _, tr := range trs {
+				for _, term := range []rdf.Term{tr.Subj, tr.Pred, tr.Obj} {
+					id, err := db.getID(tx, term)
+					if err != nil {
+						return fmt.Errorf("getID(%v): %v", term, err)
+					}
+					res[term] = id
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	before := ids()
+
+	n, err := db.ChangeBase("http://example.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("DB.ChangeBase => %d terms re-encoded; want 4", n)
+	}
+	if after := ids(); !reflect.DeepEqual(before, after) {
+		t.Errorf("term IDs changed by DB.ChangeBase: %v => %v", before, after)
+	}
+	if b := db.encoding().encode(rdf.URI("http://example.org/foo")); b[0] != 0x00 {
+		t.Errorf("URI with new base encoded as %q; want relative", b)
+	}
+	for _, tr := range trs {
+		if ok, err := db.Has(tr); err != nil || !ok {
+			t.Errorf("DB.Has(%v) after DB.ChangeBase => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+
+	// The new base is stored
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db.DB, err = Open(path, "http://example.org/"); err != nil {
+		t.Fatal(err)
+	}
+	g, err := db.Describe(rdf.URI("http://example.org/foo"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Size() != 2 || !g.Has(trs[0]) || !g.Has(trs[1]) {
+		t.Errorf("DB.Describe after reopening => %v; want %v", g.Triples(), trs[:2])
+	}
+}
+
+func TestChangeBaseConcurrently(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	var trs []rdf.Triple
+	for i := 0; i < 300; i++ {
+		trs = append(trs, rdf.Triple{
+			Subj: rdf.URI(fmt.Sprintf("http://example.org/ns/s%d", i)),
+			Pred: rdf.URI("http://test.org/p"),
+			Obj:  rdf.NewLiteral("o"),
+		})
+	}
+	insertWhileRegistering(t, db, trs, func() error {
+		for _, base := range []string{"http://example.org/", "http://example.org/ns/", "http://test.org/"} {
+			if _, err := db.ChangeBase(base); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Verify that a transaction decodes the terms with the base URI of its
+// snapshot when the base is changed after it started.
+func TestChangeBaseSnapshot(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s := rdf.URI("http://test.org/s")
+	trs := []rdf.Triple{
+		{Subj: s, Pred: rdf.URI("http://test.org/p"), Obj: rdf.URI("http://test.org/o")},
+		{Subj: s, Pred: rdf.URI("http://new.org/p"), Obj: rdf.NewLiteral("o")},
+	}
+	want := rdf.NewGraph()
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+		want.Insert(tr)
+	}
+
+	viewBefore(t, db, func() error {
+		_, err := db.ChangeBase("http://new.org/")
+		return err
+	}, func(tx *Tx) {
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), want.Triples())
+		}
+		g, err = tx.Construct(rdf.Pattern{Subj: rdf.Any, Pred: rdf.Any, Obj: rdf.Any})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Construct(* * *) => %v; want %v", g.Triples(), want.Triples())
+		}
+		for _, tr := range trs {
+			if ok, err := tx.Has(tr); err != nil || !ok {
+				t.Errorf("Tx.Has(%v) => %v, %v; want true, <nil>", tr, ok, err)
+			}
+		}
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	alice := rdf.URI("http://schema.org/alice")
+	trs := []rdf.Triple{
+		{Subj: alice, Pred: rdf.URI("http://xmlns.com/foaf/0.1/name"), Obj: rdf.NewLiteral("Alice")},
+		{Subj: rdf.URI("http://test.org/x"), Pred: rdf.URI("http://schema.org/knows"), Obj: alice},
+		{Subj: rdf.URI("http://test.org/vocab/y"), Pred: rdf.URI("http://schema.org/knows"), Obj: alice},
+	}
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	numTerms := func() int {
+		st, err := db.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.NumTerms
+	}
+	encoded := func(uri rdf.URI) (b []byte) {
+		if err := db.kv.View(func(tx *bolt.Tx) error {
+			id, err := db.getID(tx, uri)
+			if err != nil {
+				return fmt.Errorf("getID(%v): %v", uri, err)
+			}
+			b = append(b, tx.Bucket(bucketTerms).Get(u32tob(id))...)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// foafTag is the expected tag of the encoding of URIs in the FOAF namespace
+	check := func(want []string, foafTag byte) {
+		if got := db.Namespaces(); !reflect.DeepEqual(got, want) {
+			t.Errorf("DB.Namespaces() => %v; want %v", got, want)
+		}
+		for _, tr := range trs {
+			if ok, err := db.Has(tr); err != nil || !ok {
+				t.Errorf("DB.Has(%v) => %v, %v; want true, nil", tr, ok, err)
+			}
+		}
+		for uri, tag := range map[rdf.URI]byte{
+			alice:                            0xFD,
+			"http://test.org/x":              0x00,
+			"http://test.org/vocab/y":        0xFD, // the namespace is longer than the base
+			"http://xmlns.com/foaf/0.1/name": foafTag,
+		} {
+			if b := encoded(uri); b[0] != tag {
+				t.Errorf("%v encoded as %q; want tag %#x", uri, b, tag)
+			}
+		}
+	}
+
+	if err := db.RegisterNamespaces("http://schema.org/", "http://test.org/", "http://test.org/vocab/", "http://schema.org/"); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"http://schema.org/", "http://test.org/vocab/"}, 0x01)
+
+	// Namespaces are learned from the prefixes of imports, if asked to.
+	// The prefix is declared after a URI in the namespace is used, in
+	// another batch.
+	n := numTerms()
+	input := `<http://xmlns.com/foaf/0.1/name> <http://xmlns.com/foaf/0.1/name> "name" .
+@prefix foaf: <http://xmlns.com/foaf/0.1/> .
+<http://test.org/x> foaf:name "X" .`
+	if _, err := db.Import(bytes.NewBufferString(input), 1); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"http://schema.org/", "http://test.org/vocab/"}, 0x01)
+	if _, err := db.ImportWithOptions(bytes.NewBufferString(input), ImportOptions{BatchSize: 1, Workers: 2, LearnNamespaces: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := numTerms(); got != n+2 {
+		t.Errorf("got %d terms after import; want %d", got, n+2)
+	}
+	want := []string{"http://schema.org/", "http://test.org/vocab/", "http://xmlns.com/foaf/0.1/"}
+	check(want, 0xFD)
+
+	// The namespaces are persisted
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if db.DB, err = Open(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	check(want, 0xFD)
+	g, err := db.Describe(alice, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Size() != 3 {
+		t.Errorf("DB.Describe(%v) => %v; want 3 triples", alice, g.Triples())
+	}
+}
+
+// insertWhileRegistering stores the triples, and inserts them again from
+// several goroutines while register runs. No term or triple must be
+// stored twice.
+func insertWhileRegistering(t *testing.T, db *testDB, trs []rdf.Triple, register func() error) {
+	terms := make(map[rdf.Term]bool)
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+		terms[tr.Subj], terms[tr.Pred], terms[tr.Obj] = true, true, true
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := register(); err != nil {
+			errs <- err
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, tr := range trs {
+				if err := db.Insert(tr); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumTerms != len(terms) || st.NumTriples != len(trs) {
+		t.Errorf("got %d terms and %d triples; want %d and %d", st.NumTerms, st.NumTriples, len(terms), len(trs))
+	}
+	for _, tr := range trs {
+		if ok, err := db.Has(tr); err != nil || !ok {
+			t.Errorf("DB.Has(%v) => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+}
+
+func TestRegisterNamespacesConcurrently(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	var trs []rdf.Triple
+	for i := 0; i < 300; i++ {
+		trs = append(trs, rdf.Triple{
+			Subj: rdf.URI(fmt.Sprintf("http://example.org/ns/s%d", i)),
+			Pred: rdf.URI("http://example.org/p"),
+			Obj:  rdf.NewLiteral("o"),
+		})
+	}
+	insertWhileRegistering(t, db, trs, func() error {
+		for _, ns := range []string{"http://example.org/", "http://example.org/ns/", "http://example.org/ns/s1", "http://example.org/ns/s2", "http://example.org/ns/s3", "http://example.org/n"} {
+			if err := db.RegisterNamespaces(ns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Verify that a transaction decodes the terms with the namespaces of its
+// snapshot when namespaces are registered after it started.
+func TestRegisterNamespacesSnapshot(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s := rdf.URI("http://xmlns.com/foaf/0.1/alice")
+	trs := []rdf.Triple{
+		{Subj: s, Pred: rdf.URI("http://xmlns.com/foaf/0.1/name"), Obj: rdf.NewLiteral("Alice")},
+		{Subj: s, Pred: rdf.URI("http://xmlns.com/foaf/0.1/knows"), Obj: rdf.URI("http://schema.org/bob")},
+	}
+	want := rdf.NewGraph()
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+		want.Insert(tr)
+	}
+
+	viewBefore(t, db, func() error {
+		return db.RegisterNamespaces("http://xmlns.com/foaf/0.1/", "http://schema.org/")
+	}, func(tx *Tx) {
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), want.Triples())
+		}
+		for _, tr := range trs {
+			if ok, err := tx.Has(tr); err != nil || !ok {
+				t.Errorf("Tx.Has(%v) => %v, %v; want true, <nil>", tr, ok, err)
+			}
+		}
+	})
+}
+
+func TestRegisterDatatypesConcurrently(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	var dts []rdf.URI
+	var trs []rdf.Triple
+	for i := 0; i < 5; i++ {
+		dts = append(dts, rdf.URI(fmt.Sprintf("http://test.org/datatype/concurrent%d", i)))
+	}
+	for i := 0; i < 300; i++ {
+		trs = append(trs, rdf.Triple{
+			Subj: rdf.URI("http://test.org/s"),
+			Pred: rdf.URI("http://test.org/p"),
+			Obj:  rdf.NewTypedLiteral(strconv.Itoa(i), dts[i%len(dts)]),
+		})
+	}
+	insertWhileRegistering(t, db, trs, func() error {
+		for _, dt := range dts {
+			if err := db.RegisterDatatypes(dt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Verify that a transaction decodes the literals with the datatypes of its
+// snapshot when datatypes are registered after it started.
+func TestRegisterDatatypesSnapshot(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	s := rdf.URI("http://test.org/s")
+	trs := []rdf.Triple{
+		{Subj: s, Pred: rdf.URI("http://test.org/length"), Obj: rdf.NewTypedLiteral("12", rdf.URI("http://test.org/datatype/snapshot/metre"))},
+		{Subj: s, Pred: rdf.URI("http://test.org/weight"), Obj: rdf.NewTypedLiteral("7", rdf.URI("http://test.org/datatype/snapshot/gram"))},
+	}
+	want := rdf.NewGraph()
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+		want.Insert(tr)
+	}
+
+	viewBefore(t, db, func() error {
+		return db.RegisterDatatypes(trs[0].Obj.(rdf.Literal).DataType(), trs[1].Obj.(rdf.Literal).DataType())
+	}, func(tx *Tx) {
+		g, err := tx.Describe(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !g.Eq(want) {
+			t.Errorf("Tx.Describe(%v) => %v; want %v", s, g.Triples(), want.Triples())
+		}
+		for _, tr := range trs {
+			if ok, err := tx.Has(tr); err != nil || !ok {
+				t.Errorf("Tx.Has(%v) => %v, %v; want true, <nil>", tr, ok, err)
+			}
+		}
+	})
+}
+
+func TestRegisterDatatypes(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	wkt := rdf.URI("http://www.opengis.net/ont/geosparql#wktLiteral")
+	unit := rdf.URI("http://test.org/datatype/metre")
+	trs := []rdf.Triple{
+		{Subj: rdf.URI("http://test.org/x"), Pred: rdf.URI("http://test.org/at"), Obj: rdf.NewTypedLiteral("POINT(10 59)", wkt)},
+		{Subj: rdf.URI("http://test.org/x"), Pred: rdf.URI("http://test.org/height"), Obj: rdf.NewTypedLiteral("12", unit)},
+	}
+	for _, tr := range trs {
+		if err := db.Insert(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(want []rdf.URI) {
+		if got := db.Datatypes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("DB.Datatypes() => %v; want %v", got, want)
+		}
+		registered := make(map[rdf.URI]bool)
+		for _, dt := range want {
+			registered[dt] = true
+		}
+		for _, tr := range trs {
+			if ok, err := db.Has(tr); err != nil || !ok {
+				t.Errorf("DB.Has(%v) => %v, %v; want true, nil", tr, ok, err)
+			}
+			lit := tr.Obj.(rdf.Literal)
+			if err := db.kv.View(func(tx *bolt.Tx) error {
+				id, err := db.getID(tx, lit)
+				if err != nil {
+					return fmt.Errorf("getID(%v): %v", lit, err)
+				}
+				b := tx.Bucket(bucketTerms).Get(u32tob(id))
+				if (b[0] == 0xFC) != registered[lit.DataType()] {
+					t.Errorf("%v encoded as %q", lit, b)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Built-in datatypes have codes of their own, and are not registered.
+	if err := db.RegisterDatatypes(wkt, rdf.XSDstring, rdf.RDFlangString, wkt); err != nil {
+		t.Fatal(err)
+	}
+	check([]rdf.URI{wkt})
+
+	// Datatypes registered with rdf.RegisterDatatype are registered when an
+	// import starts, and when a database is opened.
+	rdf.RegisterDatatype(unit,
+		func(s string) (interface{}, error) { return strconv.ParseFloat(s, 64) },
+		func(v interface{}) (string, bool) { return "", false })
+	input := `<http://test.org/y> <http://test.org/height> "7"^^<http://test.org/datatype/metre> .`
+	if _, err := db.Import(bytes.NewBufferString(input), 10); err != nil {
+		t.Fatal(err)
+	}
+	trs = append(trs, rdf.Triple{Subj: rdf.URI("http://test.org/y"), Pred: rdf.URI("http://test.org/height"), Obj: rdf.NewTypedLiteral("7", unit)})
+	check([]rdf.URI{wkt, unit})
+
+	db2 := newTestDB()
+	defer db2.Close()
+	if got := db2.Datatypes(); len(got) == 0 || got[len(got)-1] != unit {
+		t.Errorf("DB.Datatypes() of new database => %v; want %v included", got, unit)
+	}
+
+	// The datatypes are persisted
+	path := db.kv.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if db.DB, err = Open(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	check([]rdf.URI{wkt, unit})
+
+	// The literals survive a dump and import into another database.
+	var b bytes.Buffer
+	if err := db.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db2.Import(&b, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range trs {
+		if ok, err := db2.Has(tr); err != nil || !ok {
+			t.Errorf("DB.Has(%v) after dump and import => %v, %v; want true, nil", tr, ok, err)
+		}
+	}
+}
+
+func BenchmarkImport(b *testing.B) {
+	// 50000 triples about 10000 subjects. Like in most real data, the
+	// classes and many of the objects are shared by a lot of subjects.
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "<s%d> a <Class%d> .\n", i, i%5)
+		fmt.Fprintf(&buf, "<s%d> <name> \"name %d\" .\n", i, i)
+		for j := 0; j < 3; j++ {
+			fmt.Fprintf(&buf, "<s%d> <p%d> <o%d> .\n", i, j, (i+j)%100)
+		}
+	}
+	input := buf.Bytes()
+
+	// All triples in one batch, as when loading a large dump
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := newTestDB()
+		b.StartTimer()
+		if _, err := db.Import(bytes.NewReader(input), 50000); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		db.Close()
+		b.StartTimer()
+	}
+}
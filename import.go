@@ -0,0 +1,622 @@
+package sopp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+)
+
+// maxImportCache is the maximum number of terms held in the term cache of
+// a parallel import. The cache is emptied when it grows beyond this size.
+const maxImportCache = 1 << 20
+
+// ImportOptions controls how triples are imported.
+type ImportOptions struct {
+	// BatchSize is the number of triples stored in each transaction.
+	BatchSize int
+
+	// Graph is the named graph to store the triples in. The triples are
+	// stored in the default graph if it is empty.
+	Graph rdf.URI
+
+	// Workers is the number of workers resolving terms in the parallel
+	// import pipeline. The triples are imported sequentially if it is 0.
+	Workers int
+
+	// Progress, if not nil, is called after each stored batch.
+	Progress func(ImportProgress)
+
+	// Strict aborts the import on the first triple which cannot be decoded.
+	// Otherwise such triples are skipped, until more than MaxErrors have
+	// been rejected. There is no limit if MaxErrors is 0.
+	Strict    bool
+	MaxErrors int
+
+	// Source identifies the input, ex: by its path, size and modification
+	// time. If set, a checkpoint is stored with each committed batch, and
+	// deleted when the import completes.
+	Source string
+
+	// Resume continues the import of Source from its last checkpoint. The
+	// input is positioned by seeking if it is an uncompressed io.Seeker, and
+	// by skipping the imported part otherwise. If there is no checkpoint,
+	// the import starts from the beginning.
+	Resume bool
+
+	// LearnNamespaces registers the namespaces of the @prefix directives
+	// in the input; see DB.RegisterNamespaces. Each new namespace makes
+	// the stored URIs in it re-encoded, so it is best used with input
+	// which declares a small set of prefixes.
+	LearnNamespaces bool
+
+	// OnError, if not nil, is called with each decoding error, which holds
+	// the position and the line of the rejected triple. In the parallel
+	// pipeline, it is called from the goroutine decoding the input.
+	OnError func(*rdf.DecodeError)
+}
+
+// ImportReport sums up an import.
+type ImportReport struct {
+	Read     int // number of triples decoded
+	Stored   int // number of triples stored
+	Existing int // number of triples allready stored, or repeated in the input
+	Rejected int // number of triples which could not be decoded
+}
+
+// ImportProgress describes how far an import has come.
+type ImportProgress struct {
+	Batches int   // number of batches stored
+	Triples int   // number of triples read in the stored batches, including those allready stored
+	Bytes   int64 // number of (decompressed) bytes read from the input, approximately
+}
+
+// ImportWithOptions imports triples from an Turtle stream, as controlled by
+// the options. Input compressed with gzip or bzip2 is decompressed. Like
+// Import, the labels of blank nodes are scoped to the import. If the import
+// is aborted, the batches allready stored are kept.
+func (db *DB) ImportWithOptions(r io.Reader, opts ImportOptions) (ImportReport, error) {
+	if opts.Workers > 0 {
+		return db.importParallel(r, opts)
+	}
+	return db.importBatches(r, opts)
+}
+
+// ImportCheckpoint is the state of an import from a source, as of its last
+// committed batch.
+type ImportCheckpoint struct {
+	Offset   int64              // byte offset in the input to resume from
+	Batch    int                // number of batches committed
+	Base     rdf.URI            // base URI in effect at Offset
+	Prefixes map[string]rdf.URI // prefixes in effect at Offset
+
+	scope uint64 // scope of the blank node labels
+}
+
+// Checkpoint returns the last checkpoint of the import from source,
+// or ErrNotFound if there is none.
+func (db *DB) Checkpoint(source string) (cp *ImportCheckpoint, err error) {
+	err = db.kv.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketCheckpoints).Get([]byte(source))
+		if b == nil {
+			return ErrNotFound
+		}
+		cp, err = decodeCheckpoint(b)
+		return err
+	})
+	return cp, err
+}
+
+// startImport returns the input to import, decompressed if needed, and the
+// checkpoint to start the import from. When resuming, the input is positioned
+// at the offset of the checkpoint. Datatypes registered with rdf.RegisterDatatype
+// since the database was opened are registered first.
+func (db *DB) startImport(r io.Reader, opts ImportOptions) (io.Reader, *ImportCheckpoint, error) {
+	if err := db.learnDatatypes(); err != nil {
+		return nil, nil, err
+	}
+	in, compressed, err := decompress(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Resume && opts.Source != "" {
+		cp, err := db.Checkpoint(opts.Source)
+		if err == nil {
+			if s, ok := r.(io.Seeker); ok && !compressed {
+				// The peeked bytes are discarded by seeking
+				_, err = s.Seek(cp.Offset, io.SeekStart)
+				in = r
+			} else {
+				_, err = io.CopyN(ioutil.Discard, in, cp.Offset)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			return in, cp, nil
+		}
+		if err != ErrNotFound {
+			return nil, nil, err
+		}
+	}
+	scope, err := db.newImportScope()
+	if err != nil {
+		return nil, nil, err
+	}
+	return in, &ImportCheckpoint{scope: scope}, nil
+}
+
+// Magic bytes of the supported compression formats
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh") // followed by the block size, '1'-'9'
+)
+
+// decompress returns a reader of the decompressed input, if it is compressed
+// with gzip or bzip2, and reports whether it was.
+func decompress(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4) // shorter inputs are not compressed
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		zr, err := gzip.NewReader(br)
+		return zr, true, err
+	case bytes.HasPrefix(magic, magicBzip2) && len(magic) == 4 && magic[3] >= '1' && magic[3] <= '9':
+		return bzip2.NewReader(br), true, nil
+	}
+	return br, false, nil
+}
+
+// decoder returns a decoder of the input from the checkpoint.
+func (cp *ImportCheckpoint) decoder(r io.Reader) *rdf.Decoder {
+	dec := rdf.NewDecoder(r)
+	dec.Base = cp.Base
+	for prefix, uri := range cp.Prefixes {
+		dec.SetPrefix(prefix, uri)
+	}
+	return dec
+}
+
+func putCheckpoint(tx *bolt.Tx, source string, cp *ImportCheckpoint) error {
+	return tx.Bucket(bucketCheckpoints).Put([]byte(source), cp.encode())
+}
+
+// deleteCheckpoint deletes the checkpoint of the import from source, if any.
+// It is called when the import has completed, so that there is nothing
+// left to resume.
+func (db *DB) deleteCheckpoint(source string) error {
+	if source == "" {
+		return nil
+	}
+	return db.kv.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCheckpoints).Delete([]byte(source))
+	})
+}
+
+// encode encodes the offset, batch, scope, base and number of prefixes of
+// the checkpoint, followed by each prefix and its namespace. The numbers are
+// encoded as uvarints and the strings are prefixed with their length.
+func (cp *ImportCheckpoint) encode() []byte {
+	var b bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		b.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	putString := func(s string) {
+		putUvarint(uint64(len(s)))
+		b.WriteString(s)
+	}
+	putUvarint(uint64(cp.Offset))
+	putUvarint(uint64(cp.Batch))
+	putUvarint(cp.scope)
+	putString(string(cp.Base))
+	prefixes := make([]string, 0, len(cp.Prefixes))
+	for prefix := range cp.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	putUvarint(uint64(len(prefixes)))
+	for _, prefix := range prefixes {
+		putString(prefix)
+		putString(string(cp.Prefixes[prefix]))
+	}
+	return b.Bytes()
+}
+
+func decodeCheckpoint(b []byte) (*ImportCheckpoint, error) {
+	r := bytes.NewReader(b)
+	var err error
+	getUvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(r)
+		return v
+	}
+	getString := func() string {
+		n := getUvarint()
+		if err != nil {
+			return ""
+		}
+		if n > uint64(r.Len()) {
+			err = errors.New("invalid checkpoint: string overflows")
+			return ""
+		}
+		s := make([]byte, n)
+		r.Read(s)
+		return string(s)
+	}
+
+	cp := &ImportCheckpoint{
+		Offset:   int64(getUvarint()),
+		Batch:    int(getUvarint()),
+		scope:    getUvarint(),
+		Base:     rdf.URI(getString()),
+		Prefixes: make(map[string]rdf.URI),
+	}
+	for n := getUvarint(); n > 0 && err == nil; n-- {
+		prefix := getString()
+		cp.Prefixes[prefix] = rdf.URI(getString())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// reject handles a decoding error, given the number of triples rejected
+// so far, and returns an error if the import should be aborted.
+func (opts *ImportOptions) reject(err error, rejected int) error {
+	de, ok := err.(*rdf.DecodeError)
+	if !ok {
+		return err
+	}
+	if opts.OnError != nil {
+		opts.OnError(de)
+	}
+	if opts.Strict {
+		return de
+	}
+	if opts.MaxErrors > 0 && rejected > opts.MaxErrors {
+		return ErrTooManyErrors
+	}
+	return nil
+}
+
+// ImportParallel is like Import, but the work is split into a pipeline: one
+// goroutine decodes the triples, the given number of workers encode the terms
+// and look up their IDs in a term cache, and a single writer stores the batches.
+// If workers is less than 1, one worker per CPU is used.
+//
+// The batches may be stored in another order than they appear in the input.
+// If progress is not nil, it is called by the writer after each stored batch.
+func (db *DB) ImportParallel(r io.Reader, batchSize, workers int, progress func(ImportProgress)) (int, error) {
+	return db.ImportParallelIn("", r, batchSize, workers, progress)
+}
+
+// ImportParallelIn is like ImportParallel, but stores the triples in the
+// named graph name.
+func (db *DB) ImportParallelIn(name rdf.URI, r io.Reader, batchSize, workers int, progress func(ImportProgress)) (int, error) {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	rep, err := db.importParallel(r, ImportOptions{
+		BatchSize: batchSize,
+		Graph:     name,
+		Workers:   workers,
+		Progress:  progress,
+	})
+	return rep.Stored + rep.Existing, err
+}
+
+// pendingTerm is a term of a triple on its way through the import pipeline.
+// The ID is 0 if the term was not found in the term cache.
+type pendingTerm struct {
+	term rdf.Term
+	enc  []byte
+	id   uint32
+}
+
+// pendingBatch is a batch of triples on its way through the import pipeline.
+type pendingBatch struct {
+	triples []rdf.Triple
+	terms   [][3]pendingTerm
+	epoch   uint64            // epoch of the term cache when the IDs were looked up
+	enc     encoding          // encoding of the terms
+	read    int64             // bytes read from the input when the batch was decoded
+	cp      *ImportCheckpoint // checkpoint after the batch
+}
+
+func (db *DB) importParallel(r io.Reader, opts ImportOptions) (ImportReport, error) {
+	var rep ImportReport
+	r, cp, err := db.startImport(r, opts)
+	if err != nil {
+		return rep, err
+	}
+	cache := newTermCache(atomic.LoadUint64(&db.removals))
+
+	// done is closed when the writer fails, to stop the other stages.
+	done := make(chan struct{})
+	defer close(done)
+
+	decoded := make(chan *pendingBatch, opts.Workers)
+	var dr decodeResult
+	go decodeBatches(&countingReader{r: r}, *cp, &opts, decoded, done, &dr)
+
+	resolved := make(chan *pendingBatch, opts.Workers)
+	var wg sync.WaitGroup
+	wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			db.resolveBatches(cache, cp.scope, decoded, resolved, done)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resolved)
+	}()
+
+	// As the batches may be stored out of order, the checkpoint is only
+	// moved past a batch when all the batches before it are committed.
+	committed := cp.Batch
+	uncommitted := make(map[int]*ImportCheckpoint)
+
+	var p ImportProgress
+	for b := range resolved {
+		if err := db.learnNamespaces(opts.LearnNamespaces, b.cp.Prefixes); err != nil {
+			return rep, err
+		}
+		var n int
+		if err := db.kv.Update(func(tx *bolt.Tx) error {
+			gID, err := db.targetGraph(tx, opts.Graph)
+			if err != nil {
+				return err
+			}
+			if n, err = db.storeBatch(tx, gID, cache, b); err != nil {
+				return err
+			}
+			if opts.Source == "" {
+				return nil
+			}
+			// The import fails if the transaction does, so the
+			// checkpoints can be updated before it is committed.
+			uncommitted[b.cp.Batch] = b.cp
+			var last *ImportCheckpoint
+			for c, ok := uncommitted[committed+1]; ok; c, ok = uncommitted[committed+1] {
+				delete(uncommitted, committed+1)
+				committed++
+				last = c
+			}
+			if last != nil {
+				return putCheckpoint(tx, opts.Source, last)
+			}
+			return nil
+		}); err != nil {
+			return rep, err
+		}
+		rep.Stored += n
+		rep.Existing += len(b.terms) - n
+		p.Batches++
+		p.Triples += len(b.terms)
+		if b.read > p.Bytes {
+			p.Bytes = b.read
+		}
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	// The decoder is done when all batches are resolved.
+	rep.Read = dr.read
+	rep.Rejected = dr.rejected
+	if dr.err != nil {
+		return rep, dr.err
+	}
+	return rep, db.deleteCheckpoint(opts.Source)
+}
+
+// decodeResult is the outcome of decodeBatches.
+type decodeResult struct {
+	read     int   // number of triples decoded
+	rejected int   // number of triples rejected
+	err      error // error aborting the import
+}
+
+// decodeBatches decodes triples from r and sends them in batches, until the
+// input is exhausted, the import is aborted because of decoding errors, or
+// done is closed.
+func decodeBatches(r *countingReader, cp ImportCheckpoint, opts *ImportOptions, out chan<- *pendingBatch, done <-chan struct{}, res *decodeResult) {
+	defer close(out)
+	dec := cp.decoder(r)
+	start := cp.Offset
+	b := &pendingBatch{}
+	send := func() bool {
+		cp.Offset = start + dec.Offset()
+		cp.Base = dec.Base
+		cp.Prefixes = dec.Prefixes()
+		cp.Batch++
+		b.cp = &ImportCheckpoint{}
+		*b.cp = cp
+		b.read = r.n
+		select {
+		case out <- b:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for tr, err := dec.Decode(); err != io.EOF; tr, err = dec.Decode() {
+		if _, ok := err.(*rdf.DecodeError); err != nil && !ok {
+			// Reading or decompressing the input failed
+			res.err = err
+			return
+		}
+		if err != nil {
+			res.rejected++
+			if res.err = opts.reject(err, res.rejected); res.err != nil {
+				return
+			}
+			continue
+		}
+		res.read++
+		b.triples = append(b.triples, tr)
+		if len(b.triples) == opts.BatchSize {
+			if !send() {
+				return
+			}
+			b = &pendingBatch{}
+		}
+	}
+	if len(b.triples) > 0 {
+		send()
+	}
+}
+
+// resolveBatches encodes the terms of the triples in the batches it receives,
+// and looks up their IDs in the term cache.
+func (db *DB) resolveBatches(cache *termCache, scope uint64, in <-chan *pendingBatch, out chan<- *pendingBatch, done <-chan struct{}) {
+	for b := range in {
+		b.enc = db.encoding()
+		b.terms = make([][3]pendingTerm, len(b.triples))
+		for i, tr := range b.triples {
+			for j, t := range [3]rdf.Term{scopeBlank(tr.Subj, scope), tr.Pred, scopeBlank(tr.Obj, scope)} {
+				b.terms[i][j] = pendingTerm{term: t, enc: b.enc.encode(t)}
+			}
+		}
+		b.triples = nil
+		cache.lookup(b)
+		select {
+		case out <- b:
+		case <-done:
+			return
+		}
+	}
+}
+
+// storeBatch stores the triples of the batch in the graph with ID gID,
+// adding the terms not found in the cache. It returns the number of
+// triples not allready stored.
+func (db *DB) storeBatch(tx *bolt.Tx, gID uint32, cache *termCache, b *pendingBatch) (int, error) {
+	// The IDs in the batch are stale if terms have been removed since
+	// they were looked up, as the IDs of removed terms are reused.
+	cache.sync(atomic.LoadUint64(&db.removals))
+	stale := b.epoch != cache.epoch
+
+	// The terms must be encoded again if the base URI has changed, or
+	// namespaces or datatypes have been registered since they were encoded.
+	if enc := db.encodingAt(tx); b.enc != enc {
+		for i := range b.terms {
+			for j := range b.terms[i] {
+				b.terms[i][j].enc = enc.encode(b.terms[i][j].term)
+			}
+		}
+		b.enc = enc
+		stale = true
+	}
+
+	tb := newTripleBatch()
+	for _, terms := range b.terms {
+		var ids [3]uint32
+		for i, t := range terms {
+			if t.id != 0 && !stale {
+				ids[i] = t.id
+				continue
+			}
+			id, ok := cache.get(t.enc)
+			if !ok {
+				var err error
+				if i == 1 {
+					id, err = db.addPred(tx, t.term.(rdf.URI))
+				} else {
+					id, err = db.addTermb(tx, t.enc)
+				}
+				if err != nil {
+					return 0, err
+				}
+				// The cache is only used by this import, which fails if
+				// the transaction does, so it is safe to add the ID before
+				// the transaction is committed.
+				cache.add(t.enc, id)
+			}
+			ids[i] = id
+		}
+		tb.add(ids[0], ids[1], ids[2])
+	}
+	return tb.store(tx, gID)
+}
+
+// termCache maps encoded terms to their IDs during an import.
+type termCache struct {
+	mu       sync.RWMutex
+	ids      map[string]uint32
+	epoch    uint64 // incremented every time the cache is invalidated
+	removals uint64 // DB.removals when the cache was last invalidated
+}
+
+func newTermCache(removals uint64) *termCache {
+	return &termCache{ids: make(map[string]uint32), removals: removals}
+}
+
+// lookup sets the IDs of the terms in the batch found in the cache.
+func (c *termCache) lookup(b *pendingBatch) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	b.epoch = c.epoch
+	for i := range b.terms {
+		for j := range b.terms[i] {
+			b.terms[i][j].id = c.ids[string(b.terms[i][j].enc)]
+		}
+	}
+}
+
+func (c *termCache) get(enc []byte) (uint32, bool) {
+	c.mu.RLock()
+	id, ok := c.ids[string(enc)]
+	c.mu.RUnlock()
+	return id, ok
+}
+
+func (c *termCache) add(enc []byte, id uint32) {
+	c.mu.Lock()
+	if len(c.ids) >= maxImportCache {
+		c.ids = make(map[string]uint32)
+	}
+	c.ids[string(enc)] = id
+	c.mu.Unlock()
+}
+
+// sync invalidates the cache if terms have been removed from the database.
+// It must be called within a write transaction.
+func (c *termCache) sync(removals uint64) {
+	c.mu.Lock()
+	if removals != c.removals {
+		c.ids = make(map[string]uint32)
+		c.epoch++
+		c.removals = removals
+	}
+	c.mu.Unlock()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,270 @@
+package sopp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+)
+
+// URIs in a registered namespace are stored as the ID of the namespace,
+// followed by the rest of the URI:
+//
+//   0xFD | namespace ID (uvarint) | local part
+//
+// A URI starting with both the base URI and a namespace is stored relative
+// to the longest of them, and relative to the base URI if they are equal.
+
+// nsTable is a table of namespaces. A table is not modified once it is in
+// use; registering namespaces replaces it with a new table.
+type nsTable struct {
+	ids  map[string]uint32 // namespace -> ID
+	uris map[uint32]string // ID -> namespace
+	lens []int             // lengths of the namespaces, longest first
+}
+
+func newNSTable() *nsTable {
+	return &nsTable{ids: make(map[string]uint32), uris: make(map[uint32]string)}
+}
+
+// match returns the longest namespace the URI starts with, and its ID.
+// The ID is 0 if the URI is not in any namespace.
+func (t *nsTable) match(uri string) (ns string, id uint32) {
+	// Look up the prefix of the URI of the length of each namespace,
+	// so that the cost depends on the number of distinct lengths only.
+	for _, l := range t.lens {
+		if l > len(uri) {
+			continue
+		}
+		if id, ok := t.ids[uri[:l]]; ok {
+			return uri[:l], id
+		}
+	}
+	return "", 0
+}
+
+// with returns a copy of the table with the given namespaces added.
+func (t *nsTable) with(add map[string]uint32) *nsTable {
+	res := newNSTable()
+	lens := make(map[int]bool)
+	for _, m := range []map[string]uint32{t.ids, add} {
+		for ns, id := range m {
+			res.ids[ns] = id
+			res.uris[id] = ns
+			if !lens[len(ns)] {
+				lens[len(ns)] = true
+				res.lens = append(res.lens, len(ns))
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(res.lens)))
+	return res
+}
+
+// namespaces returns the current namespace table.
+func (db *DB) namespaces() *nsTable {
+	db.muNS.RLock()
+	t := db.ns
+	db.muNS.RUnlock()
+	return t
+}
+
+// Namespaces returns the registered namespaces, in the order they were registered.
+func (db *DB) Namespaces() []string {
+	t := db.namespaces()
+	ids := make([]int, 0, len(t.uris))
+	for id := range t.uris {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	res := make([]string, len(ids))
+	for i, id := range ids {
+		res[i] = t.uris[uint32(id)]
+	}
+	return res
+}
+
+// RegisterNamespaces registers the given namespaces, so that the URIs in them
+// are stored compactly. Namespaces allready registered, or equal to the base
+// URI, are ignored. The stored URIs in the new namespaces are re-encoded.
+//
+// Transactions started before the namespaces are committed keep seeing the
+// terms as they were encoded before. Imports register the namespaces of the @prefix
+// directives in their input if ImportOptions.LearnNamespaces is set.
+func (db *DB) RegisterNamespaces(namespaces ...string) error {
+	db.muRegister.Lock()
+	defer db.muRegister.Unlock()
+
+	cur := db.namespaces()
+	var next *nsTable
+	var since int
+	err := db.kv.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucketNamespaces)
+		add := make(map[string]uint32)
+		for _, ns := range namespaces {
+			if _, ok := cur.ids[ns]; ok || ns == "" || ns == db.base {
+				continue
+			}
+			if _, ok := add[ns]; ok {
+				continue
+			}
+			n, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put(u32tob(uint32(n)), []byte(ns)); err != nil {
+				return err
+			}
+			add[ns] = uint32(n)
+		}
+		if len(add) == 0 {
+			return nil
+		}
+
+		next = cur.with(add)
+		if err := db.reencodeNamespaces(tx, cur, next, add); err != nil {
+			return err
+		}
+		// The table is replaced before the transaction commits, so that
+		// no writer after it encodes URIs with the old table.
+		since = tx.ID()
+		db.setNamespaces(next, since)
+		return nil
+	})
+	if err != nil && next != nil {
+		db.setNamespaces(cur, since)
+	}
+	return err
+}
+
+// reencodeNamespaces re-encodes the stored URIs in the namespaces added to
+// the table cur, giving the table next.
+func (db *DB) reencodeNamespaces(tx *bolt.Tx, cur, next *nsTable, add map[string]uint32) error {
+	// Only the URIs whose longest namespace is a new one are encoded
+	// differently, and their current encoding starts with the current
+	// encoding of that namespace. They are found by a prefix scan of the
+	// term index, instead of decoding every stored term.
+	var changes []termChange
+	seen := make(map[string]bool)
+	c := tx.Bucket(bucketIdxTerms).Cursor()
+	for ns := range add {
+		prefix := encodeURI(cur, db.base, ns)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if seen[string(k)] {
+				continue
+			}
+			seen[string(k)] = true
+			uri, err := decodeURI(cur, db.base, k)
+			if err != nil {
+				return err
+			}
+			if enc := encodeURI(next, db.base, string(uri)); !bytes.Equal(enc, k) {
+				// Keys and values are only valid until the bucket is modified
+				changes = append(changes, termChange{id: append([]byte(nil), v...), from: append([]byte(nil), k...), to: enc})
+			}
+		}
+	}
+	return putReencoded(tx, changes)
+}
+
+// setNamespaces replaces the namespace table, as of the transaction with
+// the given ID; see encodingAt. Encoded terms cached by imports are stale
+// when the encoding of URIs change.
+func (db *DB) setNamespaces(t *nsTable, since int) {
+	db.muNS.Lock()
+	db.ns = t
+	db.changeEncoding(since)
+	db.muNS.Unlock()
+	atomic.AddUint64(&db.removals, 1)
+}
+
+// learnNamespaces registers the namespaces of the given prefixes, if any of
+// them are not allready registered, and learn is true.
+func (db *DB) learnNamespaces(learn bool, prefixes map[string]rdf.URI) error {
+	if !learn {
+		return nil
+	}
+	t := db.namespaces()
+	var nss []string
+	for _, ns := range prefixes {
+		if _, ok := t.ids[string(ns)]; !ok && string(ns) != db.baseURI() {
+			nss = append(nss, string(ns))
+		}
+	}
+	if len(nss) == 0 {
+		return nil
+	}
+	// Register in a consistent order, so that the IDs do not depend on map order.
+	sort.Strings(nss)
+	return db.RegisterNamespaces(nss...)
+}
+
+// loadNamespaces loads the namespace table from the database.
+func (db *DB) loadNamespaces(tx *bolt.Tx) error {
+	t := readTable(tx.Bucket(bucketNamespaces))
+	db.muNS.Lock()
+	db.ns = t
+	db.muNS.Unlock()
+	return nil
+}
+
+// readTable reads a table of namespaces or datatypes from its bucket.
+func readTable(bkt *bolt.Bucket) *nsTable {
+	add := make(map[string]uint32)
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		add[string(v)] = btou32(k)
+	}
+	return newNSTable().with(add)
+}
+
+// encodeURI encodes the URI relative to the longest of the base URI and
+// the namespaces in the table it starts with, or else as an absolute URI.
+func encodeURI(t *nsTable, base string, uri string) []byte {
+	ns, id := t.match(uri)
+	inBase := strings.HasPrefix(uri, base)
+	if id != 0 && (!inBase || len(ns) > len(base)) {
+		b := make([]byte, 1+binary.MaxVarintLen32+len(uri)-len(ns))
+		b[0] = 0xFD
+		n := 1 + binary.PutUvarint(b[1:], uint64(id))
+		n += copy(b[n:], uri[len(ns):])
+		return b[:n]
+	}
+	if inBase {
+		l := len(base)
+		b := make([]byte, len(uri)-l+1)
+		copy(b[1:], uri[l:])
+		return b
+	}
+	b := make([]byte, len(uri)+1)
+	b[0] = 0x01
+	copy(b[1:], uri)
+	return b
+}
+
+// decodeURI decodes a URI encoded by encodeURI with the same table and base URI.
+func decodeURI(t *nsTable, base string, b []byte) (rdf.URI, error) {
+	switch b[0] {
+	case 0x00:
+		return rdf.URI(base + string(b[1:])), nil
+	case 0x01:
+		return rdf.URI(string(b[1:])), nil
+	case 0xFD:
+		id, n := binary.Uvarint(b[1:])
+		if n <= 0 {
+			return "", fmt.Errorf("cannot decode as URI in namespace: %v", b)
+		}
+		ns, ok := t.uris[uint32(id)]
+		if !ok {
+			return "", fmt.Errorf("cannot decode URI in unknown namespace %d: %v", id, b)
+		}
+		return rdf.URI(ns + string(b[1+n:])), nil
+	default:
+		return "", fmt.Errorf("cannot decode as URI: %v", b)
+	}
+}
@@ -0,0 +1,242 @@
+package sopp
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+)
+
+// Bindings maps variable names to the RDF terms bound to them in one
+// solution to a query.
+type Bindings map[string]rdf.Term
+
+// Query returns all solutions to the basic graph pattern made up of the given
+// triple patterns. Variables (rdf.Variable) with the same name in different
+// patterns are joined on the terms they match. Positions with rdf.Any match
+// any term without binding it.
+func (db *DB) Query(bgp ...rdf.Pattern) ([]Bindings, error) {
+	var res []Bindings
+	err := db.kv.View(func(tx *bolt.Tx) error {
+		pats, ok, err := db.compileBGP(tx, bgp)
+		if err != nil || !ok {
+			return err
+		}
+
+		// Cache decoded terms, as the same IDs will typically occur in many solutions.
+		terms := make(map[uint32]rdf.Term)
+		return db.evalBGP(tx, idRow{}, pats, func(row idRow) error {
+			b := make(Bindings, len(row))
+			for v, id := range row {
+				term, ok := terms[id]
+				if !ok {
+					if term, err = db.getTerm(tx, id); err != nil {
+						return err
+					}
+					terms[id] = term
+				}
+				b[v] = term
+			}
+			res = append(res, b)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// idRow holds the term IDs bound to variables in a (partial) solution.
+type idRow map[string]uint32
+
+// with returns a copy of the row with the variable v bound to id.
+func (r idRow) with(v string, id uint32) idRow {
+	next := make(idRow, len(r)+1)
+	for k, n := range r {
+		next[k] = n
+	}
+	next[v] = id
+	return next
+}
+
+// unify returns a copy of the row extended with the bindings given by matching
+// the pattern with a triple. It returns false if the same variable would be
+// bound to different terms.
+func (r idRow) unify(p idPattern, tr [3]uint32) (idRow, bool) {
+	next := make(idRow, len(r)+3)
+	for k, n := range r {
+		next[k] = n
+	}
+	for i, v := range p.vars {
+		if v == "" {
+			continue
+		}
+		if id, ok := next[v]; ok && id != tr[i] {
+			return nil, false
+		}
+		next[v] = tr[i]
+	}
+	return next, true
+}
+
+// idPattern is a triple pattern with its terms resolved to IDs. Each position
+// is either a term ID, a named variable, or a wildcard (ID 0 and no variable).
+type idPattern struct {
+	ids  [3]uint32
+	vars [3]string
+}
+
+// bind returns the IDs of the pattern with the variables bound in row
+// substituted, and the number of positions still unbound.
+func (p idPattern) bind(row idRow) (ids [3]uint32, free int) {
+	for i := range ids {
+		ids[i] = p.ids[i]
+		if p.vars[i] != "" {
+			ids[i] = row[p.vars[i]]
+		}
+		if ids[i] == 0 {
+			free++
+		}
+	}
+	return ids, free
+}
+
+// compileBGP resolves the terms of the given patterns into IDs. It returns
+// false if any of the terms are not stored, in which case the basic graph
+// pattern has no solutions.
+func (db *DB) compileBGP(tx *bolt.Tx, bgp []rdf.Pattern) ([]idPattern, bool, error) {
+	pats := make([]idPattern, len(bgp))
+	for n, p := range bgp {
+		for i, q := range []rdf.QVar{p.Subj, p.Pred, p.Obj} {
+			switch t := q.(type) {
+			case rdf.Variable:
+				pats[n].vars[i] = string(t)
+			case rdf.Term:
+				id, err := db.getID(tx, t)
+				if err == ErrNotFound {
+					return nil, false, nil
+				} else if err != nil {
+					return nil, false, err
+				}
+				pats[n].ids[i] = id
+			}
+		}
+	}
+	return pats, true, nil
+}
+
+// evalBGP finds all solutions to the patterns which are compatible with the
+// given row, and calls emit for each of them.
+//
+// Patterns with only one unbound position can be answered directly by the bitmap
+// stored under the key made up of the two bound positions. If several such patterns
+// share the same unbound variable, the candidates for that variable are found by
+// intersecting their bitmaps. Otherwise the index is scanned for the pattern with
+// the fewest unbound positions, and the remaining patterns are evaluated for each
+// of the matching triples.
+func (db *DB) evalBGP(tx *bolt.Tx, row idRow, pats []idPattern, emit func(idRow) error) error {
+	if len(pats) == 0 {
+		return emit(row)
+	}
+
+	type lookup struct {
+		ids [3]uint32
+		pos int // the unbound position
+	}
+
+	var (
+		rest    []idPattern
+		joinVar string
+		lookups []lookup
+	)
+	for _, p := range pats {
+		ids, free := p.bind(row)
+		switch free {
+		case 0:
+			exists, err := hasIDs(tx, ids)
+			if err != nil || !exists {
+				return err
+			}
+			continue
+		case 1:
+			pos := 0
+			for ids[pos] != 0 {
+				pos++
+			}
+			if v := p.vars[pos]; v != "" && (joinVar == "" || joinVar == v) {
+				joinVar = v
+				lookups = append(lookups, lookup{ids, pos})
+				continue
+			}
+		}
+		rest = append(rest, p)
+	}
+
+	if joinVar != "" {
+		var cands *roaring.Bitmap
+		for _, l := range lookups {
+			bitmap, err := candidates(tx, l.ids, l.pos)
+			if err != nil {
+				return err
+			}
+			if cands == nil {
+				cands = bitmap
+			} else {
+				cands.And(bitmap)
+			}
+			if cands.IsEmpty() {
+				return nil
+			}
+		}
+		it := cands.Iterator()
+		for it.HasNext() {
+			if err := db.evalBGP(tx, row.with(joinVar, it.Next()), rest, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(rest) == 0 {
+		return emit(row)
+	}
+
+	best, fewest := 0, 4
+	for i, p := range rest {
+		if _, free := p.bind(row); free < fewest {
+			best, fewest = i, free
+		}
+	}
+	p := rest[best]
+	others := make([]idPattern, 0, len(rest)-1)
+	others = append(others, rest[:best]...)
+	others = append(others, rest[best+1:]...)
+
+	ids, _ := p.bind(row)
+	return db.matchIDs(tx, ids[0], ids[1], ids[2], func(s, pr, o uint32) error {
+		next, ok := row.unify(p, [3]uint32{s, pr, o})
+		if !ok {
+			return nil
+		}
+		return db.evalBGP(tx, next, others, emit)
+	})
+}
+
+// candidates returns the bitmap of IDs which can fill the unbound position
+// pos of a triple where the two other positions are bound.
+func candidates(tx *bolt.Tx, ids [3]uint32, pos int) (*roaring.Bitmap, error) {
+	switch pos {
+	case 0:
+		return getBitmap(tx, bucketPOS, ids[1], ids[2])
+	case 1:
+		return getBitmap(tx, bucketOSP, ids[2], ids[0])
+	default:
+		return getBitmap(tx, bucketSPO, ids[0], ids[1])
+	}
+}
+
+// hasIDs checks if the triple with the given IDs is stored.
+func hasIDs(tx *bolt.Tx, ids [3]uint32) (bool, error) {
+	bitmap, err := getBitmap(tx, bucketSPO, ids[0], ids[1])
+	if err != nil {
+		return false, err
+	}
+	return bitmap.Contains(ids[2]), nil
+}
@@ -0,0 +1,121 @@
+package sopp
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+const queryTestData = `
+@base <http://test.org/> .
+<anne> a <Person> ; <name> "Anne" ; <knows> <bob>, <carl> .
+<bob> a <Person> ; <name> "Bob" ; <knows> <anne> .
+<carl> a <Person> ; <knows> <carl> .
+<dog> a <Animal> ; <name> "Fido" .
+`
+
+// rowsString returns a sorted, comparable representation of query solutions.
+func rowsString(rows []Bindings) string {
+	res := make([]string, len(rows))
+	for i, row := range rows {
+		vals := make([]string, 0, len(row))
+		for v, t := range row {
+			vals = append(vals, v+"="+t.String())
+		}
+		sort.Strings(vals)
+		res[i] = strings.Join(vals, " ")
+	}
+	sort.Strings(res)
+	return strings.Join(res, "\n")
+}
+
+func TestQuery(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if _, err := db.Import(bytes.NewBufferString(queryTestData), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		s      = rdf.Variable("s")
+		o      = rdf.Variable("o")
+		n      = rdf.Variable("n")
+		person = rdf.URI("http://test.org/Person")
+		name   = rdf.URI("http://test.org/name")
+		knows  = rdf.URI("http://test.org/knows")
+	)
+
+	tests := []struct {
+		bgp  []rdf.Pattern
+		want []string
+	}{
+		{
+			[]rdf.Pattern{{Subj: s, Pred: rdf.RDFtype, Obj: person}},
+			[]string{
+				"s=http://test.org/anne",
+				"s=http://test.org/bob",
+				"s=http://test.org/carl",
+			},
+		},
+		{
+			[]rdf.Pattern{
+				{Subj: s, Pred: rdf.RDFtype, Obj: person},
+				{Subj: s, Pred: name, Obj: n},
+			},
+			[]string{
+				"n=Anne s=http://test.org/anne",
+				"n=Bob s=http://test.org/bob",
+			},
+		},
+		{
+			[]rdf.Pattern{
+				{Subj: s, Pred: knows, Obj: o},
+				{Subj: o, Pred: name, Obj: n},
+			},
+			[]string{
+				"n=Anne o=http://test.org/anne s=http://test.org/bob",
+				"n=Bob o=http://test.org/bob s=http://test.org/anne",
+			},
+		},
+		{
+			[]rdf.Pattern{
+				{Subj: s, Pred: knows, Obj: o},
+				{Subj: o, Pred: knows, Obj: s},
+			},
+			[]string{
+				"o=http://test.org/anne s=http://test.org/bob",
+				"o=http://test.org/bob s=http://test.org/anne",
+				"o=http://test.org/carl s=http://test.org/carl",
+			},
+		},
+		{
+			[]rdf.Pattern{{Subj: s, Pred: rdf.Any, Obj: s}},
+			[]string{"s=http://test.org/carl"},
+		},
+		{
+			[]rdf.Pattern{
+				{Subj: s, Pred: name, Obj: rdf.NewLiteral("Fido")},
+				{Subj: s, Pred: rdf.RDFtype, Obj: person},
+			},
+			nil,
+		},
+		{
+			[]rdf.Pattern{{Subj: s, Pred: rdf.URI("http://test.org/missing"), Obj: rdf.Any}},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		rows, err := db.Query(test.bgp...)
+		if err != nil {
+			t.Fatalf("DB.Query(%v) failed: %v", test.bgp, err)
+		}
+		if got, want := rowsString(rows), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("DB.Query(%v) =>\n%s\nwant:\n%s", test.bgp, got, want)
+		}
+	}
+}
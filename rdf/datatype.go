@@ -0,0 +1,77 @@
+package rdf
+
+import "sync"
+
+// datatype holds the conversions of a registered datatype.
+type datatype struct {
+	uri    URI
+	parse  func(string) (interface{}, error)
+	format func(interface{}) (string, bool)
+}
+
+// The registered datatypes, in the order they were registered.
+var (
+	muDatatypes sync.RWMutex
+	datatypes   []datatype
+)
+
+// RegisterDatatype registers a datatype in addition to the built-in XSD
+// datatypes, so that Literal.Value and NewLiteral can convert its values:
+//
+// parse converts the lexical form of a Literal of the datatype to its Go value.
+// format converts a Go value to the lexical form of the datatype, and reports
+// false if the value is not of the datatype.
+//
+// NewLiteral consults the registered datatypes in the order they were
+// registered, after the built-in ones. Registering a datatype again replaces
+// its conversions. Registering a built-in datatype has no effect.
+func RegisterDatatype(dt URI, parse func(string) (interface{}, error), format func(interface{}) (string, bool)) {
+	if parse == nil || format == nil {
+		panic("rdf: RegisterDatatype with nil conversion")
+	}
+	muDatatypes.Lock()
+	defer muDatatypes.Unlock()
+	for i := range datatypes {
+		if datatypes[i].uri == dt {
+			datatypes[i].parse, datatypes[i].format = parse, format
+			return
+		}
+	}
+	datatypes = append(datatypes, datatype{uri: dt, parse: parse, format: format})
+}
+
+// Datatypes returns the registered datatypes, in the order they were registered.
+func Datatypes() []URI {
+	muDatatypes.RLock()
+	defer muDatatypes.RUnlock()
+	res := make([]URI, len(datatypes))
+	for i, dt := range datatypes {
+		res[i] = dt.uri
+	}
+	return res
+}
+
+// lookupDatatype returns the conversions of the registered datatype dt.
+func lookupDatatype(dt URI) (datatype, bool) {
+	muDatatypes.RLock()
+	defer muDatatypes.RUnlock()
+	for _, d := range datatypes {
+		if d.uri == dt {
+			return d, true
+		}
+	}
+	return datatype{}, false
+}
+
+// formatRegistered formats the value with the first registered datatype
+// accepting it.
+func formatRegistered(v interface{}) (Literal, bool) {
+	muDatatypes.RLock()
+	defer muDatatypes.RUnlock()
+	for _, d := range datatypes {
+		if s, ok := d.format(v); ok {
+			return Literal{value: s, datatype: d.uri}, true
+		}
+	}
+	return Literal{}, false
+}
@@ -0,0 +1,46 @@
+package rdf
+
+import (
+	"fmt"
+	"testing"
+)
+
+type point struct{ x, y int }
+
+func TestRegisterDatatype(t *testing.T) {
+	dt := URI("http://example.org/datatype/Point")
+	RegisterDatatype(dt,
+		func(s string) (interface{}, error) {
+			var p point
+			_, err := fmt.Sscanf(s, "POINT(%d %d)", &p.x, &p.y)
+			return p, err
+		},
+		func(v interface{}) (string, bool) {
+			p, ok := v.(point)
+			return fmt.Sprintf("POINT(%d %d)", p.x, p.y), ok
+		})
+
+	l := NewLiteral(point{1, 2})
+	if l.DataType() != dt || l.String() != "POINT(1 2)" {
+		t.Errorf("NewLiteral(%v) => %q^^%v; want %q^^%v", point{1, 2}, l.String(), l.DataType(), "POINT(1 2)", dt)
+	}
+	if v := l.Value(); v != (point{1, 2}) {
+		t.Errorf("NewLiteral(%v).Value() => %v; want %v", point{1, 2}, v, point{1, 2})
+	}
+	if v := NewTypedLiteral("LINE(1 2)", dt).Value(); v != nil {
+		t.Errorf("NewTypedLiteral(\"LINE(1 2)\", %v).Value() => %v; want nil", dt, v)
+	}
+
+	// Built-in types are not formatted by registered datatypes.
+	if l := NewLiteral(3); l.DataType() == dt {
+		t.Errorf("NewLiteral(3).DataType() => %v", dt)
+	}
+
+	found := false
+	for _, u := range Datatypes() {
+		found = found || u == dt
+	}
+	if !found {
+		t.Errorf("Datatypes() => %v; want %v included", Datatypes(), dt)
+	}
+}
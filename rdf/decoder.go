@@ -3,8 +3,22 @@ package rdf
 import (
 	"fmt"
 	"io"
+	"strings"
+	"unicode/utf8"
 )
 
+// DecodeError is a syntax error in the stream being decoded.
+type DecodeError struct {
+	Row  int    // line number
+	Col  int    // position in line (in runes, not bytes)
+	Line string // the line with the error
+	Msg  string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%d:%d %s", e.Row, e.Col, e.Msg)
+}
+
 // Decoder is a streaming decoder for RDF turtle/n-triples.
 type Decoder struct {
 	scanner *scanner
@@ -14,10 +28,10 @@ type Decoder struct {
 	tr       Triple     // parsed triple to be returned
 	keepSubj bool       // triple ended in ';' - keep subject in next call to Decode()
 	keepPred bool       // triple ended in ',' - keep predicate (and subject) in next call to Decode()
-	//skip     bool       // skip triple (because it has blank nodes and Skolemize==nil)
+	stmtEnd  int64      // byte offset of the end of the last complete statement
 
-	// Skolemize creates an URI given a blank node identifier. If not set, the triples with
-	// blank nodes will be silently discarded.
+	// Skolemize creates an URI given a blank node identifier. If not set, blank
+	// nodes are decoded as BlankNode, labeled as in the stream.
 	Skolemize func(s string) URI
 
 	// Base is the initial base URI. It will be changed by any
@@ -31,7 +45,8 @@ func NewDecoder(r io.Reader) *Decoder {
 }
 
 // Decode returns the next Triple in the input stream, or an error. The error
-// io.EOF signifies the end of the stream.
+// io.EOF signifies the end of the stream. If reading the stream fails, the
+// error is returned instead of io.EOF once the input read is decoded.
 func (d *Decoder) Decode() (Triple, error) {
 
 	var (
@@ -44,7 +59,7 @@ start:
 	tok = d.scanner.Scan()
 	switch tok.Type {
 	case tokenEOF:
-		return d.tr, io.EOF
+		return d.tr, d.eof()
 	case tokenEOL:
 		goto start
 	case tokenBaseDirective:
@@ -65,16 +80,13 @@ start:
 		}
 		goto storeObjLiteral
 	case tokenBNode:
-		if d.Skolemize == nil {
-			goto scanUntilNextStatement
-		}
 		if d.keepPred {
 			goto storeObjBNode
 		}
-		d.tr.Subj = d.Skolemize(tok.Text)
+		d.tr.Subj = d.blankNode(tok.Text)
 		goto scanPred
 	default:
-		panic("TODO default")
+		return d.errorExpected("Directive|URI|Blank node", tok)
 	}
 
 scanAndStoreBase:
@@ -134,10 +146,7 @@ storePred:
 	}
 
 storeObjBNode:
-	if d.Skolemize == nil {
-		goto scanUntilNextStatement
-	}
-	d.tr.Obj = d.Skolemize(tok.Text)
+	d.tr.Obj = d.blankNode(tok.Text)
 	goto scanTripleTermination
 
 storeObjURI:
@@ -185,19 +194,9 @@ scanDirectiveTermination:
 	if tok.Type != tokenDot {
 		return d.errorExpected("Dot", tok)
 	}
+	d.stmtEnd = d.scanner.Offset()
 	goto start // continue scanning for triples
 
-scanUntilNextStatement:
-	for {
-		tok = d.scanner.Scan()
-		switch tok.Type {
-		case tokenSemicolon, tokenComma, tokenDot:
-			goto start
-		case tokenEOF:
-			return d.tr, io.EOF
-		}
-	}
-
 scanTripleTermination:
 	tok = d.scanner.Scan()
 
@@ -214,6 +213,7 @@ actOnTripleTermination:
 	case tokenDot:
 		d.keepSubj = false
 		d.keepPred = false
+		d.stmtEnd = d.scanner.Offset()
 		// continue to done
 	case tokenEOL:
 		goto scanTripleTermination
@@ -225,23 +225,81 @@ actOnTripleTermination:
 	return d.tr, nil
 }
 
+// eof returns the error ending the stream; io.EOF, or the error reading it.
+func (d *Decoder) eof() error {
+	if d.scanner.readErr != nil {
+		return d.scanner.readErr
+	}
+	return io.EOF
+}
+
+// errorExpected returns a DecodeError for the unexpected token, and skips
+// the rest of the statement, so that decoding can continue with the next one.
 func (d *Decoder) errorExpected(expected string, tok token) (Triple, error) {
-	switch tok.Type {
-	case tokenEOF:
-		return d.tr, io.EOF
-	case tokenIllegal:
-		return d.tr, fmt.Errorf("%d:%d expected %s, found %q (%s: %s)",
-			d.scanner.Row, d.scanner.Col, expected, tok.Text, tok.Type, d.scanner.Error)
-	default:
-		return d.tr, fmt.Errorf("%d:%d expected %s, found %q (%s)",
-			d.scanner.Row, d.scanner.Col, expected, tok.Text, tok.Type)
+	if tok.Type == tokenEOF {
+		return d.tr, d.eof()
+	}
+	err := &DecodeError{
+		Row:  d.scanner.Row,
+		Col:  d.scanner.Col,
+		Line: strings.TrimRight(string(d.scanner.line), "\r\n"),
 	}
+	if tok.Type == tokenEOL {
+		// The scanner has allready moved on to the next line
+		err.Row--
+		err.Col = utf8.RuneCountInString(err.Line)
+	}
+	if tok.Type == tokenIllegal {
+		err.Msg = fmt.Sprintf("expected %s, found %q (%s: %s)", expected, tok.Text, tok.Type, d.scanner.Error)
+	} else {
+		err.Msg = fmt.Sprintf("expected %s, found %q (%s)", expected, tok.Text, tok.Type)
+	}
+
+	d.keepSubj = false
+	d.keepPred = false
+	for tok.Type != tokenDot && tok.Type != tokenEOL && tok.Type != tokenEOF {
+		tok = d.scanner.Scan()
+	}
+	if tok.Type != tokenEOF {
+		d.stmtEnd = d.scanner.Offset()
+	}
+	return d.tr, err
+}
+
+// Offset returns the byte offset in the stream of the end of the last
+// complete statement. Decoding can be resumed from there by a new Decoder,
+// given the base URI and the prefixes in effect at that point.
+func (d *Decoder) Offset() int64 {
+	return d.stmtEnd
+}
+
+// Prefixes returns the prefixes declared so far, mapped to their namespaces.
+func (d *Decoder) Prefixes() map[string]URI {
+	m := make(map[string]URI, len(d.ns.p2uri))
+	for prefix, uri := range d.ns.p2uri {
+		m[prefix] = uri
+	}
+	return m
+}
+
+// SetPrefix declares the prefix, as if by a @prefix directive.
+func (d *Decoder) SetPrefix(prefix string, uri URI) {
+	d.ns.Set(prefix, uri)
 }
 
 func (d *Decoder) resolveURI(s string) URI {
 	return URI(s).Resolve(d.Base)
 }
 
+// blankNode returns the node for the given blank node label, skolemized
+// if d.Skolemize is set.
+func (d *Decoder) blankNode(label string) Subject {
+	if d.Skolemize != nil {
+		return d.Skolemize(label)
+	}
+	return BlankNode(label)
+}
+
 func (d *Decoder) unshrinkURI(s string) URI {
 	uri, err := d.ns.Resolve(s)
 	if err != nil {
@@ -251,13 +309,12 @@ func (d *Decoder) unshrinkURI(s string) URI {
 }
 
 // DecodeGraph parses the entire stream and returns the triples as a Graph.
+// Decoding stops at the first error, which is returned.
 func (d *Decoder) DecodeGraph() (*Graph, error) {
 	g := NewGraph()
 	for tr, err := d.Decode(); err != io.EOF; tr, err = d.Decode() {
 		if err != nil {
-			fmt.Println(err)
-			fmt.Println(string(d.scanner.line))
-			panic("TODO DecodeGraph with errors")
+			return nil, err
 		}
 		g.Insert(tr)
 	}
@@ -2,7 +2,10 @@ package rdf
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -51,6 +54,8 @@ func TestDecode(t *testing.T) {
 				NewURI("p"),
 				NewLiteral(int64(9912534)),
 			}}},
+		{"@prefix ex: <http://example.org/> .\n_:b1_x ex:first_name \"a\" .", []Triple{
+			Triple{BlankNode("b1_x"), NewURI("http://example.org/first_name"), NewLiteral("a")}}},
 		{"<s> <p> true .\n <s2> <p2> false .", []Triple{
 			Triple{NewURI("s"), NewURI("p"), NewLiteral(true)},
 			Triple{NewURI("s2"), NewURI("p2"), NewLiteral(false)}}},
@@ -95,7 +100,129 @@ func TestSkolemizeBnode(t *testing.T) {
 	if !got.Eq(want) {
 		t.Errorf("got:\n%v\nwant:\n%v", got.Triples(), want.Triples())
 	}
-	// TODO test if dec.Skolemize == nil
 }
 
-func TestDecodeErrors(t *testing.T) {}
+func TestDecodeBnode(t *testing.T) {
+	input := `
+	_:a <p> "o" .
+	<s> <p> _:a ;
+    <p> <o> .`
+	got, err := NewDecoder(bytes.NewBufferString(input)).DecodeGraph()
+	if err != nil {
+		t.Fatalf("decoding:\n%q\ngot error: %v", input, err)
+	}
+	want := NewGraph()
+	want.Insert(
+		Triple{NewURI("s"), NewURI("p"), NewURI("o")},
+		Triple{BlankNode("a"), NewURI("p"), NewLiteral("o")},
+		Triple{NewURI("s"), NewURI("p"), BlankNode("a")},
+	)
+	if !got.Eq(want) {
+		t.Errorf("got:\n%v\nwant:\n%v", got.Triples(), want.Triples())
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		errs  []string // the errors, followed by the line with the error
+		want  []Triple // triples decoded in spite of the errors
+	}{
+		{"<s> <p> .\n<s> <p> <o> .", []string{
+			`1:10 expected URI|Literal, found "" (Dot)`, "<s> <p> ."},
+			[]Triple{Triple{NewURI("s"), NewURI("p"), NewURI("o")}}},
+		{"<s> <p>\n<s> <p> <o> .", []string{
+			`1:7 expected URI|Literal, found "" (EOL)`, "<s> <p>"},
+			[]Triple{Triple{NewURI("s"), NewURI("p"), NewURI("o")}}},
+		{"<s> <p> \"a\" x <o> .\n. <s> <p> \"b\" .\n<s2> <p> <o> .", []string{
+			`1:14 expected Dot|Language tag|Datatype marker, found "x" (Illegal: unexpected token)`, `<s> <p> "a" x <o> .`,
+			`2:2 expected Directive|URI|Blank node, found "" (Dot)`, `. <s> <p> "b" .`},
+			[]Triple{
+				Triple{NewURI("s"), NewURI("p"), NewLiteral("b")},
+				Triple{NewURI("s2"), NewURI("p"), NewURI("o")}}},
+	}
+
+	for _, test := range tests {
+		dec := NewDecoder(bytes.NewBufferString(test.input))
+		var errs []string
+		var got []Triple
+		for tr, err := dec.Decode(); err != io.EOF; tr, err = dec.Decode() {
+			if err != nil {
+				de, ok := err.(*DecodeError)
+				if !ok {
+					t.Fatalf("decoding:\n%q\ngot error %T; want *DecodeError", test.input, err)
+				}
+				errs = append(errs, de.Error(), de.Line)
+				continue
+			}
+			got = append(got, tr)
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("decoding:\n%q\ngot errors:\n%q\nwant:\n%q", test.input, errs, test.errs)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("decoding:\n%q\ngot:\n%v\nwant:\n%v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestDecodeResume(t *testing.T) {
+	input := "@prefix ex: <http://example.org/> .\n<s> <p> <o> .\n<s> ex:p \"a\" ;\n\tex:p \"b\" .\n@base <http://example.org/> .\n<s2> <p> <o> ."
+	want, err := NewDecoder(bytes.NewBufferString(input)).DecodeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resuming after any of the triples should give the same graph
+	for n := 1; n <= want.Size(); n++ {
+		dec := NewDecoder(bytes.NewBufferString(input))
+		got := NewGraph()
+		for i := 0; i < n; i++ {
+			tr, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.Insert(tr)
+		}
+		offset := dec.Offset()
+
+		resumed := NewDecoder(bytes.NewBufferString(input[offset:]))
+		resumed.Base = dec.Base
+		for prefix, uri := range dec.Prefixes() {
+			resumed.SetPrefix(prefix, uri)
+		}
+		rest, err := resumed.DecodeGraph()
+		if err != nil {
+			t.Fatalf("resuming at offset %d after %d triples: %v", offset, n, err)
+		}
+		for _, tr := range rest.Triples() {
+			got.Insert(tr)
+		}
+		if !got.Eq(want) {
+			t.Errorf("resuming at offset %d after %d triples: got:\n%v\nwant:\n%v", offset, n, got.Triples(), want.Triples())
+		}
+	}
+}
+
+// errReader fails with err when read.
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestDecodeReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	for _, input := range []string{
+		"<s> <p> <o> .\n",
+		"<s> <p> <o> .\n<s> <p>",
+		"<s> <p> <o> .\n<s> <p> <o2> .", // the partial line is not decoded
+	} {
+		dec := NewDecoder(io.MultiReader(strings.NewReader(input), errReader{errRead}))
+		tr, err := dec.Decode()
+		if err != nil || tr != (Triple{NewURI("s"), NewURI("p"), NewURI("o")}) {
+			t.Errorf("decoding %q => %v, %v; want first triple", input, tr, err)
+		}
+		if _, err := dec.Decode(); err != errRead {
+			t.Errorf("decoding %q => %v; want %v", input, err, errRead)
+		}
+	}
+}
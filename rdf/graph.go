@@ -20,7 +20,7 @@ const (
 // Triple represents a RDF Triple, also known as a RDF Statement.
 type Triple struct {
 	// Subj is the subject of the Triple
-	Subj URI
+	Subj Subject
 	// Pred is the predicate of the Triple
 	Pred URI
 	// Obj is the object of the triple.
@@ -29,33 +29,42 @@ type Triple struct {
 
 // String returns a N-Triples serialization of the Triple.
 func (tr Triple) String() string {
+	subj := nodeString(tr.Subj)
 	switch obj := tr.Obj.(type) {
-	case URI:
-		return fmt.Sprintf("<%s> <%s> <%s> .\n", tr.Subj, tr.Pred, obj)
+	case URI, BlankNode:
+		return fmt.Sprintf("%s <%s> %s .\n", subj, tr.Pred, nodeString(obj))
 	case Literal:
 		switch obj.DataType() {
 		case XSDstring:
-			return fmt.Sprintf("<%s> <%s> %q .\n", tr.Subj, tr.Pred, obj.value)
+			return fmt.Sprintf("%s <%s> %q .\n", subj, tr.Pred, obj.value)
 		case RDFlangString:
-			return fmt.Sprintf("<%s> <%s> %q@%s .\n", tr.Subj, tr.Pred, obj.value, obj.language)
+			return fmt.Sprintf("%s <%s> %q@%s .\n", subj, tr.Pred, obj.value, obj.language)
 		case XSDboolean:
-			return fmt.Sprintf("<%s> <%s> %s .\n", tr.Subj, tr.Pred, obj.value)
+			return fmt.Sprintf("%s <%s> %s .\n", subj, tr.Pred, obj.value)
 		default:
-			return fmt.Sprintf("<%s> <%s> %q^^<%s> .\n", tr.Subj, tr.Pred, obj.value, obj.datatype)
+			return fmt.Sprintf("%s <%s> %q^^<%s> .\n", subj, tr.Pred, obj.value, obj.datatype)
 		}
 	}
 	panic("unreachable")
 }
 
+// nodeString returns the N-Triples serialization of an URI or a BlankNode.
+func nodeString(t Term) string {
+	if b, ok := t.(BlankNode); ok {
+		return "_:" + string(b)
+	}
+	return "<" + t.String() + ">"
+}
+
 // Graph represents an RDF graph.
 type Graph struct {
-	nodes map[URI]map[URI]terms
+	nodes map[Subject]map[URI]terms
 }
 
 // NewGraph returns a new Graph.
 func NewGraph() *Graph {
 	return &Graph{
-		nodes: make(map[URI]map[URI]terms),
+		nodes: make(map[Subject]map[URI]terms),
 	}
 }
 
@@ -69,9 +78,9 @@ func (g *Graph) Size() (n int) {
 	return n
 }
 
-// Nodes return the graph as a map which node URI's as key,
+// Nodes return the graph as a map which subject nodes as key,
 // and a map of the subject's predicate URI's to Terms as value.
-func (g *Graph) Nodes() map[URI]map[URI]terms {
+func (g *Graph) Nodes() map[Subject]map[URI]terms {
 	return g.nodes
 }
 
@@ -225,10 +234,10 @@ func (g *Graph) Serialize(f Format, base string) string {
 	for subj, props := range g.nodes {
 		for pred, terms := range props {
 			for _, term := range terms {
-				fmt.Fprintf(&b, "<%s> <%s> ", subj, pred)
+				fmt.Fprintf(&b, "%s <%s> ", nodeString(subj), pred)
 				switch t := term.(type) {
-				case URI:
-					fmt.Fprintf(&b, "<%s> .\n", t)
+				case URI, BlankNode:
+					fmt.Fprintf(&b, "%s .\n", nodeString(t))
 				case Literal:
 					switch t.DataType() {
 					case RDFlangString:
@@ -256,7 +265,12 @@ func (g *Graph) SerializeWithPrefixes(base string, prefixes *PrefixMap) string {
 	fmt.Fprintln(&b)
 
 	for subj, props := range g.nodes {
-		fmt.Fprintf(&b, "<%s> ", strings.TrimPrefix(string(subj), base))
+		switch subj := subj.(type) {
+		case URI:
+			fmt.Fprintf(&b, "<%s> ", strings.TrimPrefix(string(subj), base))
+		case BlankNode:
+			fmt.Fprintf(&b, "_:%s ", subj)
+		}
 		c := 0
 		preds := make(terms, len(props))
 		for p, _ := range props {
@@ -292,6 +306,8 @@ func (g *Graph) SerializeWithPrefixes(base string, prefixes *PrefixMap) string {
 					} else {
 						fmt.Fprintf(&b, "<%s>", strings.TrimPrefix(string(t), base))
 					}
+				case BlankNode:
+					fmt.Fprintf(&b, "_:%s", t)
 				case Literal:
 					switch t.DataType() {
 					case RDFlangString:
@@ -320,7 +336,7 @@ func (g *Graph) SerializeWithPrefixes(base string, prefixes *PrefixMap) string {
 // Describe returns a graph with all the triples where the given node
 // is subject. If asObject is true, it also includes the triples where
 // the node is object.
-func (g *Graph) Describe(node URI, asObject bool) *Graph {
+func (g *Graph) Describe(node Subject, asObject bool) *Graph {
 	res := NewGraph()
 	for subj, props := range g.nodes {
 		for pred, terms := range props {
@@ -372,7 +388,7 @@ func (g *Graph) Dot(base string, focus []string) string {
 	b.WriteString("\" {\n\tnode [shape=plaintext];\n\n")
 
 	type link struct {
-		from, to URI
+		from, to Subject
 		label    string
 	}
 
@@ -462,6 +478,16 @@ func (g *Graph) Dot(base string, focus []string) string {
 						b.WriteString(strings.Join(focus, "+/") + "+/" + t.String())
 						b.WriteString("'><FONT COLOR='blue'><B>+</B></FONT></TD>\n\t</TR>\n")
 					}
+				case BlankNode:
+					if _, ok := g.nodes[t]; ok {
+						links = append(links, link{node, t, shortPred})
+						break
+					}
+					b.WriteString("\t<TR>\n\t\t<TD ALIGN='RIGHT'><B>")
+					b.WriteString(shortPred)
+					b.WriteString("</B> </TD>\n\t\t<TD ALIGN='LEFT'>_:")
+					b.WriteString(t.String())
+					b.WriteString("</TD>\n\t</TR>\n")
 				case Literal:
 					b.WriteString("\t<TR>\n\t\t<TD ALIGN='RIGHT'><B>")
 					b.WriteString(shortPred)
@@ -495,6 +521,12 @@ func (a any) validAsQVar() {}
 
 var Any = any{}
 
+// Variable is a named variable in a Pattern. Like Any it matches every term,
+// but when used in a query, the matched terms are bound to its name.
+type Variable string
+
+func (v Variable) validAsQVar() {}
+
 type matchPattern struct {
 	s, p, o bool
 }
@@ -508,11 +540,11 @@ func (g *Graph) Construct(p Pattern) *Graph {
 		var m matchPattern
 
 		switch subj := p.Subj.(type) {
-		case URI:
+		case Subject:
 			if subj == tr.Subj {
 				m.s = true
 			}
-		case any:
+		case any, Variable:
 			m.s = true
 		}
 
@@ -521,7 +553,7 @@ func (g *Graph) Construct(p Pattern) *Graph {
 			if pred == tr.Pred {
 				m.p = true
 			}
-		case any:
+		case any, Variable:
 			m.p = true
 		}
 
@@ -530,7 +562,7 @@ func (g *Graph) Construct(p Pattern) *Graph {
 			if obj == tr.Obj {
 				m.o = true
 			}
-		case any:
+		case any, Variable:
 			m.o = true
 		}
 
@@ -84,9 +84,10 @@ type scanner struct {
 	r    *bufio.Reader
 	line []byte // line being scanned
 
-	pos      int  // positon in line
-	start    int  // start of current token
-	unescape bool // true when token needs unescaping
+	pos      int   // positon in line
+	start    int   // start of current token
+	offset   int64 // byte offset of line in stream
+	unescape bool  // true when token needs unescaping
 
 	// Keep track of position in stream for error reporting:
 	Row int // line number
@@ -95,6 +96,11 @@ type scanner struct {
 	// Error holds the last encountered error explanation-
 	// It is invalidated on next call to Scan()
 	Error string
+
+	// readErr holds the error reading the stream, if other than io.EOF.
+	// The stream is scanned as if it ended before the line where the
+	// error occurred.
+	readErr error
 }
 
 func newScanner(r io.Reader) *scanner {
@@ -239,6 +245,11 @@ runeSwitch:
 	return token{tok, string(s.line[s.start+addStart : s.pos+addEnd])}
 }
 
+// Offset returns the byte offset in the stream of the scanning position.
+func (s *scanner) Offset() int64 {
+	return s.offset + int64(s.pos)
+}
+
 func (s *scanner) ignore() {
 	s.start = s.pos
 }
@@ -246,9 +257,15 @@ func (s *scanner) ignore() {
 func (s *scanner) next() rune {
 	if s.pos == len(s.line) {
 		line, err := s.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			// The partial line is not scanned
+			s.readErr = err
+			return eof
+		}
 		if err != nil && len(line) == 0 {
 			return eof
 		}
+		s.offset += int64(len(s.line))
 		s.line = line
 		s.start = 0
 		s.pos = 0
@@ -286,7 +303,7 @@ func (s *scanner) scanUntilNextToken() {
 	for {
 		r := s.peek()
 		switch r {
-		case '<', '"', '.', ';', ',', '\n', ' ', '_', eof, utf8.RuneError:
+		case '<', '"', '.', ';', ',', '\n', ' ', eof, utf8.RuneError:
 			return
 		default:
 			s.next()
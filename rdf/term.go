@@ -3,6 +3,7 @@ package rdf
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -27,6 +28,24 @@ var (
 	XSDfloat         = URI("http://www.w3.org/2001/XMLSchema#float")
 	XSDdouble        = URI("http://www.w3.org/2001/XMLSchema#double")
 	XSDdateTimeStamp = URI("http://www.w3.org/2001/XMLSchema#dateTimeStamp")
+	XSDdate          = URI("http://www.w3.org/2001/XMLSchema#date")
+	XSDdateTime      = URI("http://www.w3.org/2001/XMLSchema#dateTime")
+	XSDtime          = URI("http://www.w3.org/2001/XMLSchema#time")
+	XSDdecimal       = URI("http://www.w3.org/2001/XMLSchema#decimal")
+	XSDanyURI        = URI("http://www.w3.org/2001/XMLSchema#anyURI")
+	XSDgYear         = URI("http://www.w3.org/2001/XMLSchema#gYear")
+	XSDgYearMonth    = URI("http://www.w3.org/2001/XMLSchema#gYearMonth")
+	XSDduration      = URI("http://www.w3.org/2001/XMLSchema#duration")
+
+	XSDnonNegativeInteger = URI("http://www.w3.org/2001/XMLSchema#nonNegativeInteger")
+)
+
+// Layouts of the date and time datatypes, with and without time zone.
+var (
+	layoutsDateTime   = []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999"}
+	layoutsDate       = []string{"2006-01-02Z07:00", "2006-01-02"}
+	layoutsTime       = []string{"15:04:05.999999999Z07:00", "15:04:05.999999999"}
+	layoutsGYearMonth = []string{"2006-01Z07:00", "2006-01"}
 )
 
 // URI represents an URI node in a RDF graph.
@@ -84,6 +103,27 @@ func (u URI) validAsTerm() {}
 
 func (u URI) validAsQVar() {}
 
+// validAsSubject satiesfies the Subject interface for URI.
+func (u URI) validAsSubject() {}
+
+// BlankNode represents a node without an URI in a RDF graph. The label
+// (without the "_:" prefix) identifies the node only within the document
+// or store it was read from.
+type BlankNode string
+
+// String returns the label of the BlankNode.
+func (b BlankNode) String() string {
+	return string(b)
+}
+
+// validAsTerm satiesfies the Term interface for BlankNode.
+func (b BlankNode) validAsTerm() {}
+
+func (b BlankNode) validAsQVar() {}
+
+// validAsSubject satiesfies the Subject interface for BlankNode.
+func (b BlankNode) validAsSubject() {}
+
 // Literal represents a literal value node in a RDF graph. A Literal has
 // a value and a datatype. If the datatype is rdf:langString, it also
 // has a language tag.
@@ -114,9 +154,14 @@ type Literal struct {
 //   float64       | xsd:double
 //   string        | xsd:string
 //   time.Time     | xsd:dateTimeStamp
+//   time.Duration | xsd:duration
+//   Duration      | xsd:duration
+//   *big.Rat      | xsd:decimal
+//   URI           | xsd:anyURI
 //
-// Any other type will be given the type xsd:string,
-// and the value of fmt.Sprintf("%#v", v).
+// A value of any other type is formatted by the first registered datatype
+// accepting it (see RegisterDatatype). If none do, it will be given the
+// type xsd:string, and the value of fmt.Sprintf("%#v", v).
 func NewLiteral(v interface{}) Literal {
 	switch t := v.(type) {
 	case bool:
@@ -203,7 +248,30 @@ func NewLiteral(v interface{}) Literal {
 			value:    t.UTC().Format(time.RFC3339Nano),
 			datatype: XSDdateTimeStamp,
 		}
+	case time.Duration:
+		return Literal{
+			value:    Duration{Time: t}.String(),
+			datatype: XSDduration,
+		}
+	case Duration:
+		return Literal{
+			value:    t.String(),
+			datatype: XSDduration,
+		}
+	case *big.Rat:
+		return Literal{
+			value:    formatDecimal(t),
+			datatype: XSDdecimal,
+		}
+	case URI:
+		return Literal{
+			value:    string(t),
+			datatype: XSDanyURI,
+		}
 	default:
+		if l, ok := formatRegistered(t); ok {
+			return l
+		}
 		return Literal{
 			value:    fmt.Sprintf("%#v", t),
 			datatype: XSDstring,
@@ -212,6 +280,20 @@ func NewLiteral(v interface{}) Literal {
 }
 
 // Value returns the Literal's typed value in theS corresponding Go type.
+// The values of the datatypes not listed for NewLiteral have these types:
+//
+//   Literal datatype       | Go type
+//   -----------------------|----------
+//   xsd:date               | time.Time
+//   xsd:dateTime           | time.Time
+//   xsd:time               | time.Time (on January 1, year 0)
+//   xsd:gYear              | int
+//   xsd:gYearMonth         | time.Time (on the first day of the month)
+//   xsd:nonNegativeInteger | uint64
+//
+// A value which cannot be parsed is returned as the zero value of its type.
+// The values of registered datatypes are converted by their parse function,
+// and are nil if it fails. Other values are returned as strings.
 func (l Literal) Value() interface{} {
 	switch l.datatype {
 	case XSDboolean:
@@ -252,12 +334,193 @@ func (l Literal) Value() interface{} {
 	case XSDdateTimeStamp:
 		v, _ := time.Parse(time.RFC3339Nano, l.value)
 		return v.UTC()
+	case XSDdateTime:
+		return parseTime(layoutsDateTime, l.value)
+	case XSDdate:
+		return parseTime(layoutsDate, l.value)
+	case XSDtime:
+		return parseTime(layoutsTime, l.value)
+	case XSDgYearMonth:
+		return parseTime(layoutsGYearMonth, l.value)
+	case XSDgYear:
+		// Strip the time zone, if any
+		year := strings.TrimSuffix(l.value, "Z")
+		if n := len(year); n > 6 && (year[n-6] == '+' || year[n-6] == '-') && year[n-3] == ':' {
+			year = year[:n-6]
+		}
+		v, _ := strconv.Atoi(year)
+		return v
+	case XSDdecimal:
+		v, ok := new(big.Rat).SetString(l.value)
+		if !ok {
+			return new(big.Rat)
+		}
+		return v
+	case XSDanyURI:
+		return URI(l.value)
+	case XSDduration:
+		v, _ := ParseDuration(l.value)
+		return v
+	case XSDnonNegativeInteger:
+		v, _ := strconv.ParseUint(l.value, 10, 64)
+		return v
 	default:
+		if d, ok := lookupDatatype(l.datatype); ok {
+			v, err := d.parse(l.value)
+			if err != nil {
+				return nil
+			}
+			return v
+		}
 		// return as string
 		return l.value
 	}
 }
 
+// parseTime parses the value with the first of the layouts that matches.
+func parseTime(layouts []string, value string) time.Time {
+	for _, layout := range layouts {
+		if v, err := time.Parse(layout, value); err == nil {
+			return v
+		}
+	}
+	return time.Time{}
+}
+
+// decimalDigits is the number of decimals of a xsd:decimal created from
+// a rational number which has no finite decimal representation.
+const decimalDigits = 20
+
+// formatDecimal formats the number with as many decimals as needed
+// to represent it exactly, if possible.
+func formatDecimal(r *big.Rat) string {
+	// A fraction has a finite decimal representation if the only prime
+	// factors of the denominator are 2 and 5, and needs as many decimals
+	// as the largest power of them.
+	d := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, f := range []int64{2, 5} {
+		n := 0
+		q, m := new(big.Int), new(big.Int)
+		for {
+			q.QuoRem(d, big.NewInt(f), m)
+			if m.Sign() != 0 {
+				break
+			}
+			d.Set(q)
+			n++
+		}
+		if n > digits {
+			digits = n
+		}
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		digits = decimalDigits
+	}
+	return r.FloatString(digits)
+}
+
+// Duration is the value of a xsd:duration Literal. The years and months are
+// kept apart from the days and time, as their length in time varies.
+// Both parts of a negative duration must be negative.
+type Duration struct {
+	Months int           // years * 12 + months
+	Time   time.Duration // days, hours, minutes and seconds
+}
+
+// ParseDuration parses a xsd:duration, ex: P1Y2M3DT4H5M6.7S.
+func ParseDuration(s string) (Duration, error) {
+	var d Duration
+	in := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if len(s) < 2 || s[0] != 'P' || s[len(s)-1] == 'T' {
+		return d, fmt.Errorf("invalid xsd:duration: %q", in)
+	}
+	s = s[1:]
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' && !inTime {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "YMDHS")
+		if i < 1 {
+			return d, fmt.Errorf("invalid xsd:duration: %q", in)
+		}
+		num, unit := s[:i], s[i]
+		s = s[i+1:]
+		if unit == 'S' && inTime {
+			secs, err := time.ParseDuration(num + "s")
+			if err != nil {
+				return d, fmt.Errorf("invalid xsd:duration: %q", in)
+			}
+			d.Time += secs
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return d, fmt.Errorf("invalid xsd:duration: %q", in)
+		}
+		switch {
+		case !inTime && unit == 'Y':
+			d.Months += 12 * n
+		case !inTime && unit == 'M':
+			d.Months += n
+		case !inTime && unit == 'D':
+			d.Time += time.Duration(n) * 24 * time.Hour
+		case inTime && unit == 'H':
+			d.Time += time.Duration(n) * time.Hour
+		case inTime && unit == 'M':
+			d.Time += time.Duration(n) * time.Minute
+		default:
+			return d, fmt.Errorf("invalid xsd:duration: %q", in)
+		}
+	}
+	if neg {
+		d.Months, d.Time = -d.Months, -d.Time
+	}
+	return d, nil
+}
+
+// String returns the duration in the lexical form of xsd:duration.
+func (d Duration) String() string {
+	months, t := d.Months, d.Time
+	var b bytes.Buffer
+	if months < 0 || t < 0 {
+		b.WriteByte('-')
+		months, t = -months, -t
+	}
+	b.WriteByte('P')
+	if y := months / 12; y > 0 {
+		fmt.Fprintf(&b, "%dY", y)
+	}
+	if m := months % 12; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	if days := t / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		t -= days * 24 * time.Hour
+	}
+	if t > 0 || (months == 0 && b.Len() <= 2) {
+		b.WriteByte('T')
+		if h := t / time.Hour; h > 0 {
+			fmt.Fprintf(&b, "%dH", h)
+			t -= h * time.Hour
+		}
+		if m := t / time.Minute; m > 0 {
+			fmt.Fprintf(&b, "%dM", m)
+			t -= m * time.Minute
+		}
+		if t > 0 || b.Bytes()[b.Len()-1] == 'T' {
+			b.WriteString(strconv.FormatFloat(t.Seconds(), 'f', -1, 64))
+			b.WriteByte('S')
+		}
+	}
+	return b.String()
+}
+
 // String returns the Literal's value as a string.
 func (l Literal) String() string {
 	return l.value
@@ -295,7 +558,7 @@ func NewTypedLiteral(v string, dt URI) Literal {
 	}
 }
 
-// Term represents a RDF Term: the combination of URI and Literal.
+// Term represents a RDF Term: the combination of URI, BlankNode and Literal.
 type Term interface {
 	// String returns a string represenation of a Term
 	String() string
@@ -304,6 +567,16 @@ type Term interface {
 	validAsTerm()
 }
 
+// Subject represents a Term which can be the subject of a Triple,
+// meaning an URI or a BlankNode.
+type Subject interface {
+	Term
+
+	// methods are not exported to hinder interface implementations outside this package:
+	validAsQVar()
+	validAsSubject()
+}
+
 // terms is a slice of Term. (Necessary to make it sortable)
 type terms []Term
 
@@ -2,6 +2,7 @@ package rdf
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 	"testing"
 	"time"
@@ -81,6 +82,80 @@ func TestNewLiteral(t *testing.T) {
 	}
 }
 
+func TestLiteralValue(t *testing.T) {
+	tests := []struct {
+		value string
+		dt    URI
+		want  interface{}
+	}{
+		{"2016-02-29", XSDdate, time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"2016-02-29+02:00", XSDdate, time.Date(2016, 2, 29, 0, 0, 0, 0, time.FixedZone("", 2*60*60))},
+		{"2016-02-29T23:59:01.5Z", XSDdateTime, time.Date(2016, 2, 29, 23, 59, 1, 5e8, time.UTC)},
+		{"2016-02-29T23:59:01", XSDdateTime, time.Date(2016, 2, 29, 23, 59, 1, 0, time.UTC)},
+		{"12:30:00", XSDtime, time.Date(0, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{"12:30:00-05:00", XSDtime, time.Date(0, 1, 1, 17, 30, 0, 0, time.UTC)},
+		{"2016-02", XSDgYearMonth, time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"1999", XSDgYear, 1999},
+		{"-0044", XSDgYear, -44},
+		{"2016Z", XSDgYear, 2016},
+		{"2016+02:00", XSDgYear, 2016},
+		{"3.14", XSDdecimal, big.NewRat(314, 100)},
+		{"-0.5", XSDdecimal, big.NewRat(-1, 2)},
+		{"x", XSDdecimal, new(big.Rat)},
+		{"http://example.org/a", XSDanyURI, URI("http://example.org/a")},
+		{"P1Y2M3DT4H5M6.5S", XSDduration, Duration{Months: 14, Time: 3*24*time.Hour + 4*time.Hour + 5*time.Minute + 6500*time.Millisecond}},
+		{"-PT1M", XSDduration, Duration{Time: -time.Minute}},
+		{"PT", XSDduration, Duration{}},
+		{"18446744073709551615", XSDnonNegativeInteger, uint64(18446744073709551615)},
+	}
+	for _, test := range tests {
+		got := NewTypedLiteral(test.value, test.dt).Value()
+		eq := got == test.want
+		switch want := test.want.(type) {
+		case time.Time:
+			v, ok := got.(time.Time)
+			eq = ok && v.Equal(want)
+		case *big.Rat:
+			v, ok := got.(*big.Rat)
+			eq = ok && v.Cmp(want) == 0
+		}
+		if !eq {
+			t.Errorf("NewTypedLiteral(%q, %v).Value() => %v; want %v", test.value, test.dt, got, test.want)
+		}
+	}
+}
+
+func TestNewLiteralMoreTypes(t *testing.T) {
+	tests := []struct {
+		in    interface{}
+		dt    URI
+		value string
+	}{
+		{big.NewRat(314, 100), XSDdecimal, "3.14"},
+		{big.NewRat(-3, 8), XSDdecimal, "-0.375"},
+		{big.NewRat(7, 1), XSDdecimal, "7"},
+		{big.NewRat(1, 3), XSDdecimal, "0.33333333333333333333"},
+		{URI("http://example.org/a"), XSDanyURI, "http://example.org/a"},
+		{90 * time.Minute, XSDduration, "PT1H30M"},
+		{time.Duration(0), XSDduration, "PT0S"},
+		{-(26*time.Hour + 1500*time.Millisecond), XSDduration, "-P1DT2H1.5S"},
+		{Duration{Months: 25}, XSDduration, "P2Y1M"},
+		{Duration{Months: 1, Time: 24 * time.Hour}, XSDduration, "P1M1D"},
+	}
+	for _, test := range tests {
+		l := NewLiteral(test.in)
+		if l.DataType() != test.dt || l.String() != test.value {
+			t.Errorf("NewLiteral(%v) => %q^^%v; want %q^^%v", test.in, l.String(), l.DataType(), test.value, test.dt)
+		}
+	}
+
+	for _, s := range []string{"", "P", "1Y", "P1S", "PT1Y", "P1YT", "PxY", "P1.5D"} {
+		if d, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q) => %v; want error", s, d)
+		}
+	}
+}
+
 func TestNewLiteralArchDependent(t *testing.T) {
 	// Test that float and int types get the corresponding 32/64-bit datatypes
 	// Note that the type returned by Value() will not be typed as uint/int/float,
@@ -0,0 +1,385 @@
+package sopp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+	"github.com/boutros/sopp/sparql"
+)
+
+// errStop is used to stop the evaluation of a query early.
+var errStop = errors.New("stop")
+
+// Sparql parses and executes the given SPARQL query.
+func (db *DB) Sparql(query string) (*sparql.Results, error) {
+	q, err := sparql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return db.Exec(q)
+}
+
+// Exec executes the given SPARQL query.
+func (db *DB) Exec(q *sparql.Query) (*sparql.Results, error) {
+	res := &sparql.Results{Form: q.Form}
+	err := db.kv.View(func(tx *bolt.Tx) error {
+		e := executor{db: db, tx: tx, terms: make(map[uint32]rdf.Term)}
+
+		switch q.Form {
+		case sparql.Ask:
+			err := e.solve(q.Where, func(sparql.Solution) error {
+				res.Boolean = true
+				return errStop
+			})
+			if err == errStop {
+				err = nil
+			}
+			return err
+		case sparql.Describe:
+			if q.Where == nil {
+				res.Graph = rdf.NewGraph()
+				return e.describe(q.Resources, []sparql.Solution{{}}, res.Graph)
+			}
+		}
+
+		var sols []sparql.Solution
+		limit := -1
+		if len(q.OrderBy) == 0 && !q.Distinct && q.Limit >= 0 {
+			// No need to find more solutions than we will return
+			limit = q.Offset + q.Limit
+		}
+		if limit != 0 {
+			err := e.solve(q.Where, func(sol sparql.Solution) error {
+				sols = append(sols, sol)
+				if len(sols) == limit {
+					return errStop
+				}
+				return nil
+			})
+			if err != nil && err != errStop {
+				return err
+			}
+		}
+		sols = q.Modify(sols)
+
+		switch q.Form {
+		case sparql.Select:
+			for _, v := range q.Vars {
+				res.Vars = append(res.Vars, string(v))
+			}
+			res.Solutions = sols
+		case sparql.Construct:
+			res.Graph = construct(q.Template, sols)
+		case sparql.Describe:
+			res.Graph = rdf.NewGraph()
+			return e.describe(q.Resources, sols, res.Graph)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SparqlUpdate parses and executes the given SPARQL update request.
+func (db *DB) SparqlUpdate(update string) error {
+	u, err := sparql.ParseUpdate(update)
+	if err != nil {
+		return err
+	}
+	return db.ExecUpdate(u)
+}
+
+// ExecUpdate executes the given SPARQL update request. All the operations are
+// performed in a single transaction, so if one of them fails, none of them
+// take effect.
+func (db *DB) ExecUpdate(u *sparql.Update) error {
+	return db.kv.Update(func(tx *bolt.Tx) error {
+		for _, op := range u.Operations {
+			if err := db.execOperation(tx, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DB) execOperation(tx *bolt.Tx, op sparql.Operation) error {
+	var del, ins *rdf.Graph
+	if op.Where == nil {
+		var err error
+		if del, err = dataGraph(op.Delete); err != nil {
+			return err
+		}
+		if ins, err = dataGraph(op.Insert); err != nil {
+			return err
+		}
+	} else {
+		// All solutions must be found before the store is modified.
+		var sols []sparql.Solution
+		e := executor{db: db, tx: tx, terms: make(map[uint32]rdf.Term)}
+		if err := e.solve(op.Where, func(sol sparql.Solution) error {
+			sols = append(sols, sol)
+			return nil
+		}); err != nil {
+			return err
+		}
+		del = construct(op.Delete, sols)
+		ins = construct(op.Insert, sols)
+	}
+
+	for _, tr := range del.Triples() {
+		if err := db.delete(tx, 0, tr); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	for _, tr := range ins.Triples() {
+		if err := db.insert(tx, 0, tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataGraph returns the triples of INSERT DATA or DELETE DATA as a graph.
+func dataGraph(data []rdf.Pattern) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	for _, p := range data {
+		subj, ok := p.Subj.(rdf.Subject)
+		if !ok {
+			return nil, fmt.Errorf("invalid triple: subject must be an URI or a blank node: %v", p.Subj)
+		}
+		pred, ok := p.Pred.(rdf.URI)
+		if !ok {
+			return nil, fmt.Errorf("invalid triple: predicate must be an URI: %v", p.Pred)
+		}
+		g.Insert(rdf.Triple{Subj: subj, Pred: pred, Obj: p.Obj.(rdf.Term)})
+	}
+	return g, nil
+}
+
+// executor evaluates graph patterns within a transaction.
+type executor struct {
+	db    *DB
+	tx    *bolt.Tx
+	terms map[uint32]rdf.Term // cache of decoded terms
+}
+
+// solve finds all solutions to the group graph pattern and calls emit for each of them.
+func (e *executor) solve(g *sparql.Group, emit func(sparql.Solution) error) error {
+	cg, err := e.compile(g)
+	if err != nil {
+		return err
+	}
+	return e.evalGroup(idRow{}, cg, func(row idRow) error {
+		sol, err := e.solution(row)
+		if err != nil {
+			return err
+		}
+		return emit(sol)
+	})
+}
+
+// group is a group graph pattern with the terms in the basic graph
+// patterns resolved into IDs.
+type group struct {
+	elems   []element
+	filters []sparql.Expr
+}
+
+type elementKind int
+
+const (
+	elementBGP elementKind = iota
+	elementOptional
+	elementUnion
+	elementGroup
+)
+
+type element struct {
+	kind  elementKind
+	bgp   []idPattern
+	bgpOK bool     // false if any of the BGP's terms are not stored
+	group *group   // OPTIONAL or nested group
+	alts  []*group // UNION alternatives
+}
+
+func (e *executor) compile(g *sparql.Group) (*group, error) {
+	cg := &group{filters: g.Filters}
+	for _, el := range g.Elements {
+		var ce element
+		var err error
+		switch el := el.(type) {
+		case sparql.BGP:
+			ce.kind = elementBGP
+			ce.bgp, ce.bgpOK, err = e.db.compileBGP(e.tx, el)
+		case sparql.Optional:
+			ce.kind = elementOptional
+			ce.group, err = e.compile(el.Group)
+		case sparql.Union:
+			ce.kind = elementUnion
+			for _, alt := range el {
+				var cg *group
+				if cg, err = e.compile(alt); err != nil {
+					break
+				}
+				ce.alts = append(ce.alts, cg)
+			}
+		case *sparql.Group:
+			ce.kind = elementGroup
+			ce.group, err = e.compile(el)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cg.elems = append(cg.elems, ce)
+	}
+	return cg, nil
+}
+
+// evalGroup finds all solutions to the group which are compatible with the
+// given row, and calls emit for each of the solutions satisfying the filters.
+func (e *executor) evalGroup(row idRow, g *group, emit func(idRow) error) error {
+	return e.evalElems(row, g.elems, func(row idRow) error {
+		if len(g.filters) > 0 {
+			sol, err := e.solution(row)
+			if err != nil {
+				return err
+			}
+			for _, f := range g.filters {
+				if !sparql.Holds(f, sol) {
+					return nil
+				}
+			}
+		}
+		return emit(row)
+	})
+}
+
+// evalElems joins the elements of a group in order.
+func (e *executor) evalElems(row idRow, elems []element, emit func(idRow) error) error {
+	if len(elems) == 0 {
+		return emit(row)
+	}
+	el := elems[0]
+	next := func(row idRow) error {
+		return e.evalElems(row, elems[1:], emit)
+	}
+
+	switch el.kind {
+	case elementBGP:
+		if !el.bgpOK {
+			return nil
+		}
+		return e.db.evalBGP(e.tx, row, el.bgp, next)
+	case elementOptional:
+		found := false
+		if err := e.evalGroup(row, el.group, func(row idRow) error {
+			found = true
+			return next(row)
+		}); err != nil {
+			return err
+		}
+		if !found {
+			return next(row)
+		}
+		return nil
+	case elementUnion:
+		for _, alt := range el.alts {
+			if err := e.evalGroup(row, alt, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return e.evalGroup(row, el.group, next)
+	}
+}
+
+// solution decodes the terms of the row.
+func (e *executor) solution(row idRow) (sparql.Solution, error) {
+	sol := make(sparql.Solution, len(row))
+	for v, id := range row {
+		term, ok := e.terms[id]
+		if !ok {
+			var err error
+			if term, err = e.db.getTerm(e.tx, id); err != nil {
+				return nil, err
+			}
+			e.terms[id] = term
+		}
+		sol[v] = term
+	}
+	return sol, nil
+}
+
+// describe inserts the description of the given resources into the graph.
+// Variables are described for every solution they are bound in.
+func (e *executor) describe(resources []rdf.QVar, sols []sparql.Solution, g *rdf.Graph) error {
+	done := make(map[rdf.Subject]bool)
+	for _, sol := range sols {
+		for _, r := range resources {
+			var node rdf.Subject
+			switch r := r.(type) {
+			case rdf.URI:
+				node = r
+			case rdf.Variable:
+				subj, ok := sol[string(r)].(rdf.Subject)
+				if !ok {
+					continue
+				}
+				node = subj
+			}
+			if done[node] {
+				continue
+			}
+			done[node] = true
+			if err := e.db.describe(e.tx, 0, node, false, g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// construct instantiates the template for each solution. Triples with unbound
+// variables, or which would not be valid RDF, are left out. A blank node in
+// the template is a new blank node for each solution.
+func construct(template []rdf.Pattern, sols []sparql.Solution) *rdf.Graph {
+	g := rdf.NewGraph()
+	for n, sol := range sols {
+	eachPattern:
+		for _, p := range template {
+			var terms [3]rdf.Term
+			for i, q := range []rdf.QVar{p.Subj, p.Pred, p.Obj} {
+				switch t := q.(type) {
+				case rdf.Variable:
+					if sparql.IsBlank(t) {
+						terms[i] = rdf.BlankNode(fmt.Sprintf("b%d_%s", n, string(t[2:])))
+						continue
+					}
+					term, ok := sol[string(t)]
+					if !ok {
+						continue eachPattern
+					}
+					terms[i] = term
+				case rdf.Term:
+					terms[i] = t
+				}
+			}
+			subj, ok := terms[0].(rdf.Subject)
+			if !ok {
+				continue
+			}
+			pred, ok := terms[1].(rdf.URI)
+			if !ok {
+				continue
+			}
+			g.Insert(rdf.Triple{Subj: subj, Pred: pred, Obj: terms[2]})
+		}
+	}
+	return g
+}
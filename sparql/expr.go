@@ -0,0 +1,535 @@
+package sparql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+var (
+	errUnbound   = errors.New("unbound variable")
+	errTypeError = errors.New("type error")
+)
+
+// Expr is an expression, as found in FILTER and ORDER BY clauses.
+type Expr interface {
+	// Eval evaluates the expression given the variable bindings of
+	// a solution. An error signifies a SPARQL expression error, ex: a
+	// type error or an unbound variable.
+	Eval(Solution) (rdf.Term, error)
+}
+
+// Holds reports whether the effective boolean value of the expression is
+// true, given the solution. Expression errors count as false, in accordance
+// with the semantics of FILTER.
+func Holds(e Expr, sol Solution) bool {
+	t, err := e.Eval(sol)
+	if err != nil {
+		return false
+	}
+	b, err := ebv(t)
+	return err == nil && b
+}
+
+type varExpr rdf.Variable
+
+func (e varExpr) Eval(sol Solution) (rdf.Term, error) {
+	if t, ok := sol[string(e)]; ok {
+		return t, nil
+	}
+	return nil, errUnbound
+}
+
+type termExpr struct {
+	term rdf.Term
+}
+
+func (e termExpr) Eval(Solution) (rdf.Term, error) {
+	return e.term, nil
+}
+
+type notExpr struct {
+	arg Expr
+}
+
+func (e notExpr) Eval(sol Solution) (rdf.Term, error) {
+	t, err := e.arg.Eval(sol)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ebv(t)
+	if err != nil {
+		return nil, err
+	}
+	return rdf.NewLiteral(!b), nil
+}
+
+type negExpr struct {
+	arg Expr
+}
+
+func (e negExpr) Eval(sol Solution) (rdf.Term, error) {
+	t, err := e.arg.Eval(sol)
+	if err != nil {
+		return nil, err
+	}
+	return arithmetic("-", rdf.NewTypedLiteral("0", rdf.XSDinteger), t)
+}
+
+type binaryExpr struct {
+	op   string
+	l, r Expr
+}
+
+func (e binaryExpr) Eval(sol Solution) (rdf.Term, error) {
+	switch e.op {
+	case "||", "&&":
+		// Logical operators are evaluated according to the three-valued logic
+		// of SPARQL: an error in one operand can be masked by the other.
+		lb, lerr := e.operand(sol, e.l)
+		rb, rerr := e.operand(sol, e.r)
+		if e.op == "||" {
+			if (lerr == nil && lb) || (rerr == nil && rb) {
+				return rdf.NewLiteral(true), nil
+			}
+		} else {
+			if (lerr == nil && !lb) || (rerr == nil && !rb) {
+				return rdf.NewLiteral(false), nil
+			}
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		return rdf.NewLiteral(e.op == "&&"), nil
+	}
+
+	l, err := e.l.Eval(sol)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.r.Eval(sol)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "=", "!=":
+		eq, err := equal(l, r)
+		if err != nil {
+			return nil, err
+		}
+		return rdf.NewLiteral(eq == (e.op == "=")), nil
+	case "<", ">", "<=", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		var b bool
+		switch e.op {
+		case "<":
+			b = c < 0
+		case ">":
+			b = c > 0
+		case "<=":
+			b = c <= 0
+		case ">=":
+			b = c >= 0
+		}
+		return rdf.NewLiteral(b), nil
+	default:
+		return arithmetic(e.op, l, r)
+	}
+}
+
+func (e binaryExpr) operand(sol Solution, arg Expr) (bool, error) {
+	t, err := arg.Eval(sol)
+	if err != nil {
+		return false, err
+	}
+	return ebv(t)
+}
+
+type callExpr struct {
+	fn   string // upper-cased function name
+	args []Expr
+}
+
+// builtins maps the supported builtin functions to their number of arguments.
+// A negative number means the minimum number of arguments.
+var builtins = map[string]int{
+	"BOUND":       1,
+	"ISIRI":       1,
+	"ISURI":       1,
+	"ISLITERAL":   1,
+	"ISBLANK":     1,
+	"ISNUMERIC":   1,
+	"STR":         1,
+	"LANG":        1,
+	"DATATYPE":    1,
+	"STRLEN":      1,
+	"UCASE":       1,
+	"LCASE":       1,
+	"CONTAINS":    2,
+	"STRSTARTS":   2,
+	"STRENDS":     2,
+	"SAMETERM":    2,
+	"LANGMATCHES": 2,
+	"REGEX":       -2,
+}
+
+func (e callExpr) Eval(sol Solution) (rdf.Term, error) {
+	if e.fn == "BOUND" {
+		v, ok := e.args[0].(varExpr)
+		if !ok {
+			return nil, errTypeError
+		}
+		_, bound := sol[string(v)]
+		return rdf.NewLiteral(bound), nil
+	}
+
+	args := make([]rdf.Term, len(e.args))
+	for i, arg := range e.args {
+		t, err := arg.Eval(sol)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	switch e.fn {
+	case "ISIRI", "ISURI":
+		_, ok := args[0].(rdf.URI)
+		return rdf.NewLiteral(ok), nil
+	case "ISLITERAL":
+		_, ok := args[0].(rdf.Literal)
+		return rdf.NewLiteral(ok), nil
+	case "ISBLANK":
+		_, ok := args[0].(rdf.BlankNode)
+		return rdf.NewLiteral(ok), nil
+	case "ISNUMERIC":
+		l, ok := args[0].(rdf.Literal)
+		return rdf.NewLiteral(ok && isNumeric(l)), nil
+	case "STR":
+		if _, ok := args[0].(rdf.BlankNode); ok {
+			return nil, errTypeError
+		}
+		return rdf.NewLiteral(args[0].String()), nil
+	case "LANG":
+		l, ok := args[0].(rdf.Literal)
+		if !ok {
+			return nil, errTypeError
+		}
+		return rdf.NewLiteral(l.Lang()), nil
+	case "DATATYPE":
+		l, ok := args[0].(rdf.Literal)
+		if !ok {
+			return nil, errTypeError
+		}
+		return l.DataType(), nil
+	case "SAMETERM":
+		return rdf.NewLiteral(args[0] == args[1]), nil
+	}
+
+	// The remaining functions all take string literals as arguments.
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		l, ok := arg.(rdf.Literal)
+		if !ok || (l.DataType() != rdf.XSDstring && l.DataType() != rdf.RDFlangString) {
+			return nil, errTypeError
+		}
+		strs[i] = l.String()
+	}
+	str := args[0].(rdf.Literal)
+
+	switch e.fn {
+	case "STRLEN":
+		return rdf.NewTypedLiteral(strconv.Itoa(utf8.RuneCountInString(strs[0])), rdf.XSDinteger), nil
+	case "UCASE":
+		return sameKind(str, strings.ToUpper(strs[0])), nil
+	case "LCASE":
+		return sameKind(str, strings.ToLower(strs[0])), nil
+	case "CONTAINS":
+		return rdf.NewLiteral(strings.Contains(strs[0], strs[1])), nil
+	case "STRSTARTS":
+		return rdf.NewLiteral(strings.HasPrefix(strs[0], strs[1])), nil
+	case "STRENDS":
+		return rdf.NewLiteral(strings.HasSuffix(strs[0], strs[1])), nil
+	case "LANGMATCHES":
+		tag, rng := strings.ToLower(strs[0]), strings.ToLower(strs[1])
+		if rng == "*" {
+			return rdf.NewLiteral(tag != ""), nil
+		}
+		return rdf.NewLiteral(tag == rng || strings.HasPrefix(tag, rng+"-")), nil
+	case "REGEX":
+		pattern := strs[1]
+		if len(strs) > 2 && strs[2] != "" {
+			for _, f := range strs[2] {
+				if f != 'i' && f != 'm' && f != 's' {
+					return nil, fmt.Errorf("unsupported regex flag: %q", f)
+				}
+			}
+			pattern = "(?" + strs[2] + ")" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return rdf.NewLiteral(re.MatchString(strs[0])), nil
+	}
+	return nil, fmt.Errorf("unsupported function: %s", e.fn)
+}
+
+// sameKind returns a string literal with the same language tag as l.
+func sameKind(l rdf.Literal, s string) rdf.Literal {
+	if l.DataType() == rdf.RDFlangString {
+		return rdf.NewLangLiteral(s, l.Lang())
+	}
+	return rdf.NewLiteral(s)
+}
+
+// ebv returns the effective boolean value of a term.
+func ebv(t rdf.Term) (bool, error) {
+	l, ok := t.(rdf.Literal)
+	if !ok {
+		return false, errTypeError
+	}
+	switch {
+	case l.DataType() == rdf.XSDboolean:
+		return l.String() == "true" || l.String() == "1", nil
+	case l.DataType() == rdf.XSDstring:
+		return l.String() != "", nil
+	case isNumeric(l):
+		f, err := strconv.ParseFloat(l.String(), 64)
+		if err != nil {
+			return false, nil
+		}
+		return f != 0 && !math.IsNaN(f), nil
+	}
+	return false, errTypeError
+}
+
+func isInteger(l rdf.Literal) bool {
+	switch l.DataType() {
+	case rdf.XSDinteger, rdf.XSDint, rdf.XSDlong, rdf.XSDshort, rdf.XSDbyte,
+		rdf.XSDunsignedByte, rdf.XSDunsignedShort, rdf.XSDunsignedInt, rdf.XSDunsignedLong,
+		rdf.XSDnonNegativeInteger:
+		return true
+	}
+	return false
+}
+
+func isNumeric(l rdf.Literal) bool {
+	switch l.DataType() {
+	case rdf.XSDfloat, rdf.XSDdouble, rdf.XSDdecimal:
+		return true
+	}
+	return isInteger(l)
+}
+
+// numbers returns the numeric values of the two terms. If both are integers,
+// the integer values are returned and ints is true.
+func numbers(a, b rdf.Term) (x, y float64, i, j int64, ints bool, err error) {
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if !ok1 || !ok2 || !isNumeric(la) || !isNumeric(lb) {
+		return 0, 0, 0, 0, false, errTypeError
+	}
+	if isInteger(la) && isInteger(lb) {
+		i, err1 := strconv.ParseInt(la.String(), 10, 64)
+		j, err2 := strconv.ParseInt(lb.String(), 10, 64)
+		if err1 == nil && err2 == nil {
+			return float64(i), float64(j), i, j, true, nil
+		}
+	}
+	if x, err = strconv.ParseFloat(la.String(), 64); err != nil {
+		return 0, 0, 0, 0, false, errTypeError
+	}
+	if y, err = strconv.ParseFloat(lb.String(), 64); err != nil {
+		return 0, 0, 0, 0, false, errTypeError
+	}
+	return x, y, 0, 0, false, nil
+}
+
+func arithmetic(op string, a, b rdf.Term) (rdf.Term, error) {
+	x, y, i, j, ints, err := numbers(a, b)
+	if err != nil {
+		return nil, err
+	}
+	if ints && op != "/" {
+		var v int64
+		switch op {
+		case "+":
+			v = i + j
+		case "-":
+			v = i - j
+		case "*":
+			v = i * j
+		}
+		return rdf.NewTypedLiteral(strconv.FormatInt(v, 10), rdf.XSDinteger), nil
+	}
+	var v float64
+	switch op {
+	case "+":
+		v = x + y
+	case "-":
+		v = x - y
+	case "*":
+		v = x * y
+	case "/":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		v = x / y
+	}
+	return rdf.NewLiteral(v), nil
+}
+
+// equal tests two terms for equality, comparing literals by value where
+// their datatypes are known.
+func equal(a, b rdf.Term) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	c, err := compare(a, b)
+	if err == nil {
+		return c == 0, nil
+	}
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if ok1 && ok2 && la.DataType() == lb.DataType() && !known(la) {
+		// Literals of unknown datatypes with different lexical forms may still
+		// represent the same value.
+		return false, errTypeError
+	}
+	return false, nil
+}
+
+func known(l rdf.Literal) bool {
+	switch l.DataType() {
+	case rdf.XSDstring, rdf.RDFlangString, rdf.XSDboolean, rdf.XSDdate:
+		return true
+	}
+	return isNumeric(l) || isDateTime(l)
+}
+
+// isDateTime reports whether the literal is a xsd:dateTime, or of a
+// datatype derived from it.
+func isDateTime(l rdf.Literal) bool {
+	switch l.DataType() {
+	case rdf.XSDdateTime, rdf.XSDdateTimeStamp:
+		return true
+	}
+	return false
+}
+
+// compare compares two literals by value. It returns an error if the
+// literals cannot be compared.
+func compare(a, b rdf.Term) (int, error) {
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if !ok1 || !ok2 {
+		return 0, errTypeError
+	}
+	switch {
+	case isNumeric(la) && isNumeric(lb):
+		x, y, i, j, ints, err := numbers(a, b)
+		if err != nil {
+			return 0, err
+		}
+		if ints {
+			return cmpInt(i, j), nil
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		case x == y:
+			return 0, nil
+		}
+		return 0, errTypeError // NaN
+	case la.DataType() == rdf.XSDstring && lb.DataType() == rdf.XSDstring:
+		return strings.Compare(la.String(), lb.String()), nil
+	case la.DataType() == rdf.XSDboolean && lb.DataType() == rdf.XSDboolean:
+		x, _ := ebv(la)
+		y, _ := ebv(lb)
+		switch {
+		case x == y:
+			return 0, nil
+		case y:
+			return -1, nil
+		}
+		return 1, nil
+	case isDateTime(la) && isDateTime(lb),
+		la.DataType() == rdf.XSDdate && lb.DataType() == rdf.XSDdate:
+		// Values which cannot be parsed are returned as the zero time.
+		x, _ := la.Value().(time.Time)
+		y, _ := lb.Value().(time.Time)
+		if x.IsZero() || y.IsZero() {
+			return 0, errTypeError
+		}
+		switch {
+		case x.Before(y):
+			return -1, nil
+		case x.After(y):
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, errTypeError
+}
+
+func cmpInt(i, j int64) int {
+	switch {
+	case i < j:
+		return -1
+	case i > j:
+		return 1
+	}
+	return 0
+}
+
+// orderCompare compares two terms according to the ordering of ORDER BY:
+// unbound < blank nodes < URIs < literals. Literals which cannot be compared by value are
+// ordered by their lexical form, datatype and language.
+func orderCompare(a, b rdf.Term) int {
+	rank := func(t rdf.Term) int {
+		switch t.(type) {
+		case nil:
+			return 0
+		case rdf.BlankNode:
+			return 1
+		case rdf.URI:
+			return 2
+		}
+		return 3
+	}
+	if ra, rb := rank(a), rank(b); ra != rb || ra == 0 {
+		return cmpInt(int64(ra), int64(rb))
+	}
+	if c, err := compare(a, b); err == nil {
+		return c
+	}
+	if c := strings.Compare(a.String(), b.String()); c != 0 {
+		return c
+	}
+	la, ok1 := a.(rdf.Literal)
+	lb, ok2 := b.(rdf.Literal)
+	if ok1 && ok2 {
+		if c := strings.Compare(string(la.DataType()), string(lb.DataType())); c != 0 {
+			return c
+		}
+		return strings.Compare(la.Lang(), lb.Lang())
+	}
+	return 0
+}
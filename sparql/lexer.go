@@ -0,0 +1,362 @@
+package sparql
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF     tokenType = iota
+	tokenIRI               // <http://example.org/>
+	tokenPName             // prefix:local
+	tokenBNode             // _:label
+	tokenVar               // ?name or $name
+	tokenString            // "string" or 'string'
+	tokenLangTag           // @lang
+	tokenInteger           // 1
+	tokenDecimal           // 1.0
+	tokenDouble            // 1.0e0
+	tokenKeyword           // SELECT, FILTER, a, true ...
+	tokenPunct             // { } ( ) [ ] . ; , * ^^ ! = != < > <= >= && || + - /
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokenEOF:
+		return "EOF"
+	case tokenIRI:
+		return "IRI"
+	case tokenPName:
+		return "prefixed name"
+	case tokenBNode:
+		return "blank node"
+	case tokenVar:
+		return "variable"
+	case tokenString:
+		return "string"
+	case tokenLangTag:
+		return "language tag"
+	case tokenInteger, tokenDecimal, tokenDouble:
+		return "number"
+	case tokenKeyword:
+		return "keyword"
+	case tokenPunct:
+		return "punctuation"
+	default:
+		return "unknown token"
+	}
+}
+
+type token struct {
+	typ  tokenType
+	text string
+	pos  int // byte offset in input
+}
+
+// lex splits the input into tokens.
+func lex(input string) ([]token, error) {
+	l := lexer{input: input}
+	var toks []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.typ == tokenEOF {
+			return toks, nil
+		}
+	}
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	line, col := position(l.input, pos)
+	return fmt.Errorf("%d:%d %s", line, col, fmt.Sprintf(format, args...))
+}
+
+// position returns the line and column of the given byte offset.
+func position(input string, pos int) (line, col int) {
+	if pos > len(input) {
+		pos = len(input)
+	}
+	line = 1 + strings.Count(input[:pos], "\n")
+	col = 1 + utf8.RuneCountInString(input[strings.LastIndex(input[:pos], "\n")+1:pos])
+	return line, col
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.pos+n < len(l.input) {
+		return l.input[l.pos+n]
+	}
+	return 0
+}
+
+func (l *lexer) next() (token, error) {
+	// skip whitespace and comments
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		if c == '#' {
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			break
+		}
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{tokenEOF, "", start}, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '<':
+		if tok, ok := l.lexIRI(); ok {
+			return tok, nil
+		}
+		if l.peek(1) == '=' {
+			l.pos += 2
+			return token{tokenPunct, "<=", start}, nil
+		}
+		l.pos++
+		return token{tokenPunct, "<", start}, nil
+	case c == '?' || c == '$':
+		l.pos++
+		for l.pos < len(l.input) {
+			r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			l.pos += w
+		}
+		name := l.input[start+1 : l.pos]
+		if name == "" {
+			return token{}, l.errorf(start, "empty variable name")
+		}
+		return token{tokenVar, name, start}, nil
+	case c == '"' || c == '\'':
+		return l.lexString()
+	case c == '@':
+		l.pos++
+		for l.pos < len(l.input) && (isAlnum(l.input[l.pos]) || l.input[l.pos] == '-') {
+			l.pos++
+		}
+		if l.pos == start+1 {
+			return token{}, l.errorf(start, "empty language tag")
+		}
+		return token{tokenLangTag, l.input[start+1 : l.pos], start}, nil
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		return l.lexNumber(), nil
+	case c == '_' && l.peek(1) == ':':
+		l.pos += 2
+		name := l.lexName()
+		if name == "" {
+			return token{}, l.errorf(start, "empty blank node label")
+		}
+		return token{tokenBNode, name, start}, nil
+	case c == ':' || isNameStart(l.input[l.pos:]):
+		name := l.lexName()
+		if l.pos < len(l.input) && l.input[l.pos] == ':' {
+			l.pos++
+			l.lexLocal()
+			return token{tokenPName, l.input[start:l.pos], start}, nil
+		}
+		return token{tokenKeyword, name, start}, nil
+	}
+
+	// punctuation
+	if l.pos+1 < len(l.input) {
+		switch two := l.input[l.pos : l.pos+2]; two {
+		case "^^", "!=", ">=", "&&", "||":
+			l.pos += 2
+			return token{tokenPunct, two, start}, nil
+		}
+	}
+	switch c {
+	case '{', '}', '(', ')', '[', ']', '.', ';', ',', '*', '!', '=', '>', '+', '-', '/':
+		l.pos++
+		return token{tokenPunct, string(c), start}, nil
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return token{}, l.errorf(start, "unexpected character %q", r)
+}
+
+// lexIRI tries to lex an IRI reference. It returns false if the input
+// at the current position is not an IRI, in which case '<' is an operator.
+func (l *lexer) lexIRI() (token, bool) {
+	for i := l.pos + 1; i < len(l.input); i++ {
+		switch l.input[i] {
+		case '>':
+			tok := token{tokenIRI, l.input[l.pos+1 : i], l.pos}
+			l.pos = i + 1
+			return tok, true
+		case ' ', '\t', '\n', '\r', '<', '"', '{', '}', '|', '^', '`', '\\':
+			return token{}, false
+		}
+	}
+	return token{}, false
+}
+
+// lexName lexes a variable name, prefix or keyword.
+func (l *lexer) lexName() string {
+	start := l.pos
+	for l.pos < len(l.input) {
+		r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+		if r != '_' && r != '-' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		l.pos += w
+	}
+	// names cannot end with a dot
+	for l.pos > start && l.input[l.pos-1] == '.' {
+		l.pos--
+	}
+	return l.input[start:l.pos]
+}
+
+// lexLocal lexes the local part of a prefixed name.
+func (l *lexer) lexLocal() {
+	for l.pos < len(l.input) {
+		r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+		if r != '_' && r != '-' && r != '.' && r != ':' && r != '%' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		l.pos += w
+	}
+	for l.input[l.pos-1] == '.' {
+		l.pos--
+	}
+}
+
+func (l *lexer) lexNumber() token {
+	start := l.pos
+	typ := tokenInteger
+	for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos < len(l.input) && l.input[l.pos] == '.' && isDigit(l.peek(1)) {
+		typ = tokenDecimal
+		l.pos++
+		for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		i := l.pos + 1
+		if i < len(l.input) && (l.input[i] == '+' || l.input[i] == '-') {
+			i++
+		}
+		if i < len(l.input) && isDigit(l.input[i]) {
+			typ = tokenDouble
+			l.pos = i
+			for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	return token{typ, l.input[start:l.pos], start}
+}
+
+func (l *lexer) lexString() (token, error) {
+	start := l.pos
+	q := l.input[l.pos]
+	long := l.peek(1) == q && l.peek(2) == q
+	if long {
+		l.pos += 3
+	} else {
+		l.pos++
+	}
+
+	var b bytes.Buffer
+	for {
+		if l.pos >= len(l.input) {
+			return token{}, l.errorf(start, "unterminated string")
+		}
+		c := l.input[l.pos]
+		switch {
+		case c == q && !long:
+			l.pos++
+			return token{tokenString, b.String(), start}, nil
+		case c == q && l.peek(1) == q && l.peek(2) == q:
+			l.pos += 3
+			return token{tokenString, b.String(), start}, nil
+		case (c == '\n' || c == '\r') && !long:
+			return token{}, l.errorf(start, "unterminated string")
+		case c == '\\':
+			l.pos++
+			r, err := l.lexEscape()
+			if err != nil {
+				return token{}, err
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) lexEscape() (rune, error) {
+	if l.pos >= len(l.input) {
+		return 0, l.errorf(l.pos, "unterminated escape sequence")
+	}
+	c := l.input[l.pos]
+	l.pos++
+	switch c {
+	case 't':
+		return '\t', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case '"', '\'', '\\':
+		return rune(c), nil
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if l.pos+n > len(l.input) {
+			return 0, l.errorf(l.pos, "invalid unicode escape")
+		}
+		v, err := strconv.ParseUint(l.input[l.pos:l.pos+n], 16, 32)
+		if err != nil {
+			return 0, l.errorf(l.pos, "invalid unicode escape")
+		}
+		l.pos += n
+		return rune(v), nil
+	}
+	return 0, l.errorf(l.pos-2, "invalid escape sequence \\%c", c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || unicode.IsLetter(r)
+}
@@ -0,0 +1,754 @@
+package sparql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+// Parse parses a SPARQL query.
+func Parse(query string) (*Query, error) {
+	p, err := newParser(query)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseQuery()
+}
+
+func newParser(input string) (*parser, error) {
+	toks, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{input: input, toks: toks, prefixes: make(map[string]string)}, nil
+}
+
+// parser is a recursive descent parser of SPARQL queries.
+type parser struct {
+	input    string
+	toks     []token
+	pos      int
+	base     rdf.URI
+	prefixes map[string]string
+	bnodes   int // counter for anonymous blank nodes
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.typ != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	line, col := position(p.input, tok.pos)
+	return fmt.Errorf("%d:%d %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) errorExpected(expected string, tok token) error {
+	if tok.typ == tokenEOF {
+		return p.errorf(tok, "expected %s, found EOF", expected)
+	}
+	return p.errorf(tok, "expected %s, found %q (%s)", expected, tok.text, tok.typ)
+}
+
+// isKeyword checks if the token is the given (case-insensitive) keyword.
+func isKeyword(tok token, kw string) bool {
+	return tok.typ == tokenKeyword && strings.EqualFold(tok.text, kw)
+}
+
+func isPunct(tok token, s string) bool {
+	return tok.typ == tokenPunct && tok.text == s
+}
+
+// accept consumes the next token if it is the given keyword or punctuation.
+func (p *parser) accept(s string) bool {
+	tok := p.peek()
+	if isPunct(tok, s) || isKeyword(tok, s) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes the next token, which must be the given keyword or punctuation.
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorExpected(strconv.Quote(s), p.peek())
+	}
+	return nil
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	if err := p.parsePrologue(); err != nil {
+		return nil, err
+	}
+
+	q := &Query{Limit: -1}
+	var err error
+	tok := p.next()
+	switch {
+	case isKeyword(tok, "SELECT"):
+		q.Form = Select
+		err = p.parseSelect(q)
+	case isKeyword(tok, "ASK"):
+		q.Form = Ask
+		q.Where, err = p.parseWhere()
+	case isKeyword(tok, "CONSTRUCT"):
+		q.Form = Construct
+		err = p.parseConstruct(q)
+	case isKeyword(tok, "DESCRIBE"):
+		q.Form = Describe
+		err = p.parseDescribe(q)
+	default:
+		return nil, p.errorExpected("SELECT|ASK|CONSTRUCT|DESCRIBE", tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.parseSolutionModifiers(q); err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokenEOF {
+		return nil, p.errorExpected("EOF", tok)
+	}
+	return q, nil
+}
+
+func (p *parser) parsePrologue() error {
+	for {
+		tok := p.peek()
+		switch {
+		case isKeyword(tok, "BASE"):
+			p.next()
+			tok = p.next()
+			if tok.typ != tokenIRI {
+				return p.errorExpected("IRI", tok)
+			}
+			p.base = rdf.URI(tok.text).Resolve(p.base)
+		case isKeyword(tok, "PREFIX"):
+			p.next()
+			tok = p.next()
+			if tok.typ != tokenPName || !strings.HasSuffix(tok.text, ":") {
+				return p.errorExpected("prefix", tok)
+			}
+			prefix := strings.TrimSuffix(tok.text, ":")
+			tok = p.next()
+			if tok.typ != tokenIRI {
+				return p.errorExpected("IRI", tok)
+			}
+			p.prefixes[prefix] = string(rdf.URI(tok.text).Resolve(p.base))
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseSelect(q *Query) error {
+	if p.accept("DISTINCT") {
+		q.Distinct = true
+	} else {
+		// REDUCED permits, but does not require, eliminating duplicates.
+		p.accept("REDUCED")
+	}
+
+	star := p.accept("*")
+	if !star {
+		for p.peek().typ == tokenVar {
+			q.Vars = append(q.Vars, rdf.Variable(p.next().text))
+		}
+		if len(q.Vars) == 0 {
+			return p.errorExpected("variable or '*'", p.peek())
+		}
+	}
+
+	var err error
+	if q.Where, err = p.parseWhere(); err != nil {
+		return err
+	}
+	if star {
+		q.Vars = groupVars(q.Where, nil)
+	}
+	return nil
+}
+
+func (p *parser) parseConstruct(q *Query) error {
+	if isKeyword(p.peek(), "WHERE") {
+		// CONSTRUCT WHERE { ... } is short for using the basic graph pattern
+		// as template
+		var err error
+		if q.Where, err = p.parseWhere(); err != nil {
+			return err
+		}
+		if len(q.Where.Elements) > 1 || len(q.Where.Filters) > 0 {
+			return p.errorf(p.peek(), "CONSTRUCT WHERE only allows a basic graph pattern")
+		}
+		for _, el := range q.Where.Elements {
+			if bgp, ok := el.(BGP); ok {
+				q.Template = bgp
+			} else {
+				return p.errorf(p.peek(), "CONSTRUCT WHERE only allows a basic graph pattern")
+			}
+		}
+		return nil
+	}
+
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	var err error
+	if q.Template, err = p.parseTriples(nil); err != nil {
+		return err
+	}
+	if err = p.expect("}"); err != nil {
+		return err
+	}
+	q.Where, err = p.parseWhere()
+	return err
+}
+
+func (p *parser) parseDescribe(q *Query) error {
+	star := p.accept("*")
+	if !star {
+		for {
+			tok := p.peek()
+			if tok.typ == tokenVar {
+				p.next()
+				q.Resources = append(q.Resources, rdf.Variable(tok.text))
+				continue
+			}
+			if tok.typ == tokenIRI || tok.typ == tokenPName {
+				uri, err := p.parseIRI()
+				if err != nil {
+					return err
+				}
+				q.Resources = append(q.Resources, uri)
+				continue
+			}
+			break
+		}
+		if len(q.Resources) == 0 {
+			return p.errorExpected("variable, IRI or '*'", p.peek())
+		}
+	}
+
+	if tok := p.peek(); isKeyword(tok, "WHERE") || isPunct(tok, "{") {
+		var err error
+		if q.Where, err = p.parseWhere(); err != nil {
+			return err
+		}
+	}
+	if star {
+		if q.Where == nil {
+			return p.errorExpected("WHERE", p.peek())
+		}
+		for _, v := range groupVars(q.Where, nil) {
+			q.Resources = append(q.Resources, v)
+		}
+	}
+	return nil
+}
+
+// parseWhere parses a WHERE clause. The WHERE keyword is optional.
+func (p *parser) parseWhere() (*Group, error) {
+	p.accept("WHERE")
+	return p.parseGroup()
+}
+
+func (p *parser) parseGroup() (*Group, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	g := &Group{}
+	for {
+		tok := p.peek()
+		switch {
+		case isPunct(tok, "}"):
+			p.next()
+			return g, nil
+		case isKeyword(tok, "FILTER"):
+			p.next()
+			e, err := p.parseConstraint()
+			if err != nil {
+				return nil, err
+			}
+			g.Filters = append(g.Filters, e)
+		case isKeyword(tok, "OPTIONAL"):
+			p.next()
+			opt, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			g.Elements = append(g.Elements, Optional{opt})
+		case isPunct(tok, "{"):
+			sub, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			if !isKeyword(p.peek(), "UNION") {
+				g.Elements = append(g.Elements, sub)
+				break
+			}
+			union := Union{sub}
+			for p.accept("UNION") {
+				if sub, err = p.parseGroup(); err != nil {
+					return nil, err
+				}
+				union = append(union, sub)
+			}
+			g.Elements = append(g.Elements, union)
+		case isPunct(tok, "."):
+			p.next()
+		default:
+			bgp, err := p.parseTriples(nil)
+			if err != nil {
+				return nil, err
+			}
+			if len(bgp) == 0 {
+				return nil, p.errorExpected("triple pattern, FILTER, OPTIONAL, '{' or '}'", p.peek())
+			}
+			// Adjacent triple blocks (ex: separated by a FILTER) form one BGP.
+			if n := len(g.Elements); n > 0 {
+				if prev, ok := g.Elements[n-1].(BGP); ok {
+					g.Elements[n-1] = append(prev, bgp...)
+					break
+				}
+			}
+			g.Elements = append(g.Elements, BGP(bgp))
+		}
+	}
+}
+
+// parseTriples parses a block of triple patterns, separated by '.'.
+func (p *parser) parseTriples(pats []rdf.Pattern) ([]rdf.Pattern, error) {
+	for {
+		if !startsTerm(p.peek()) {
+			return pats, nil
+		}
+
+		subj, err := p.parseVarOrTerm()
+		if err != nil {
+			return nil, err
+		}
+		if pats, err = p.parsePropertyList(subj, pats); err != nil {
+			return nil, err
+		}
+		if !p.accept(".") {
+			return pats, nil
+		}
+	}
+}
+
+// startsTerm checks if the token can start a variable or RDF term.
+func startsTerm(tok token) bool {
+	switch tok.typ {
+	case tokenVar, tokenBNode, tokenIRI, tokenPName, tokenString, tokenInteger, tokenDecimal, tokenDouble:
+		return true
+	case tokenKeyword:
+		return isKeyword(tok, "true") || isKeyword(tok, "false")
+	case tokenPunct:
+		return tok.text == "[" || tok.text == "-" || tok.text == "+"
+	}
+	return false
+}
+
+func (p *parser) parsePropertyList(subj rdf.QVar, pats []rdf.Pattern) ([]rdf.Pattern, error) {
+	for {
+		var pred rdf.QVar
+		tok := p.peek()
+		switch {
+		case tok.typ == tokenKeyword && tok.text == "a":
+			p.next()
+			pred = rdf.RDFtype
+		case tok.typ == tokenVar:
+			p.next()
+			pred = rdf.Variable(tok.text)
+		default:
+			uri, err := p.parseIRI()
+			if err != nil {
+				return nil, err
+			}
+			pred = uri
+		}
+
+		for {
+			obj, err := p.parseVarOrTerm()
+			if err != nil {
+				return nil, err
+			}
+			pats = append(pats, rdf.Pattern{Subj: subj, Pred: pred, Obj: obj})
+			if !p.accept(",") {
+				break
+			}
+		}
+
+		if !p.accept(";") {
+			return pats, nil
+		}
+		// allow trailing ';'
+		if tok := p.peek(); isPunct(tok, ".") || isPunct(tok, "}") {
+			return pats, nil
+		}
+	}
+}
+
+func (p *parser) parseVarOrTerm() (rdf.QVar, error) {
+	tok := p.peek()
+	switch tok.typ {
+	case tokenVar:
+		p.next()
+		return rdf.Variable(tok.text), nil
+	case tokenBNode:
+		p.next()
+		return rdf.Variable("_:" + tok.text), nil
+	case tokenPunct:
+		if tok.text == "[" {
+			p.next()
+			if err := p.expect("]"); err != nil {
+				return nil, p.errorf(tok, "blank node property lists are not supported")
+			}
+			p.bnodes++
+			return rdf.Variable(fmt.Sprintf("_:b%d", p.bnodes)), nil
+		}
+	}
+	t, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	return t.(rdf.QVar), nil
+}
+
+// parseTerm parses an IRI or a literal.
+func (p *parser) parseTerm() (rdf.Term, error) {
+	tok := p.peek()
+	switch tok.typ {
+	case tokenIRI, tokenPName:
+		return p.parseIRI()
+	case tokenString:
+		p.next()
+		next := p.peek()
+		switch {
+		case next.typ == tokenLangTag:
+			p.next()
+			return rdf.NewLangLiteral(tok.text, next.text), nil
+		case isPunct(next, "^^"):
+			p.next()
+			dt, err := p.parseIRI()
+			if err != nil {
+				return nil, err
+			}
+			return rdf.NewTypedLiteral(tok.text, dt), nil
+		}
+		return rdf.NewLiteral(tok.text), nil
+	case tokenInteger, tokenDecimal, tokenDouble:
+		p.next()
+		return numericLiteral(tok, ""), nil
+	case tokenPunct:
+		if tok.text == "-" || tok.text == "+" {
+			p.next()
+			num := p.next()
+			switch num.typ {
+			case tokenInteger, tokenDecimal, tokenDouble:
+				return numericLiteral(num, tok.text), nil
+			}
+			return nil, p.errorExpected("number", num)
+		}
+	case tokenKeyword:
+		if isKeyword(tok, "true") || isKeyword(tok, "false") {
+			p.next()
+			return rdf.NewTypedLiteral(strings.ToLower(tok.text), rdf.XSDboolean), nil
+		}
+	}
+	return nil, p.errorExpected("variable, IRI or literal", tok)
+}
+
+func numericLiteral(tok token, sign string) rdf.Literal {
+	if sign == "+" {
+		sign = ""
+	}
+	switch tok.typ {
+	case tokenInteger:
+		return rdf.NewTypedLiteral(sign+tok.text, rdf.XSDinteger)
+	case tokenDecimal:
+		return rdf.NewTypedLiteral(sign+tok.text, rdf.XSDdecimal)
+	default:
+		return rdf.NewTypedLiteral(sign+tok.text, rdf.XSDdouble)
+	}
+}
+
+// parseIRI parses an IRI reference or a prefixed name.
+func (p *parser) parseIRI() (rdf.URI, error) {
+	tok := p.next()
+	switch tok.typ {
+	case tokenIRI:
+		return rdf.URI(tok.text).Resolve(p.base), nil
+	case tokenPName:
+		i := strings.Index(tok.text, ":")
+		ns, ok := p.prefixes[tok.text[:i]]
+		if !ok {
+			return "", p.errorf(tok, "undefined prefix: %q", tok.text[:i])
+		}
+		return rdf.URI(ns + tok.text[i+1:]), nil
+	}
+	return "", p.errorExpected("IRI", tok)
+}
+
+func (p *parser) parseSolutionModifiers(q *Query) error {
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return err
+		}
+		for {
+			tok := p.peek()
+			var cond OrderCondition
+			switch {
+			case isKeyword(tok, "ASC"), isKeyword(tok, "DESC"):
+				p.next()
+				cond.Desc = isKeyword(tok, "DESC")
+				if err := p.expect("("); err != nil {
+					return err
+				}
+				e, err := p.parseExpr()
+				if err != nil {
+					return err
+				}
+				if err := p.expect(")"); err != nil {
+					return err
+				}
+				cond.Expr = e
+			case tok.typ == tokenVar:
+				p.next()
+				cond.Expr = varExpr(tok.text)
+			case isPunct(tok, "("), tok.typ == tokenKeyword && builtins[strings.ToUpper(tok.text)] != 0:
+				e, err := p.parseConstraint()
+				if err != nil {
+					return err
+				}
+				cond.Expr = e
+			default:
+				if len(q.OrderBy) == 0 {
+					return p.errorExpected("order condition", tok)
+				}
+			}
+			if cond.Expr == nil {
+				break
+			}
+			q.OrderBy = append(q.OrderBy, cond)
+		}
+	}
+
+	for {
+		var n *int
+		tok := p.peek()
+		switch {
+		case isKeyword(tok, "LIMIT"):
+			n = &q.Limit
+		case isKeyword(tok, "OFFSET"):
+			n = &q.Offset
+		default:
+			return nil
+		}
+		p.next()
+		num := p.next()
+		if num.typ != tokenInteger {
+			return p.errorExpected("integer", num)
+		}
+		v, err := strconv.Atoi(num.text)
+		if err != nil {
+			return p.errorf(num, "invalid integer: %v", err)
+		}
+		*n = v
+	}
+}
+
+// parseConstraint parses a bracketted expression or a function call.
+func (p *parser) parseConstraint() (Expr, error) {
+	if p.accept("(") {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	tok := p.peek()
+	if tok.typ != tokenKeyword {
+		return nil, p.errorExpected("'(' or function call", tok)
+	}
+	return p.parseCall()
+}
+
+// Expressions, in order of increasing precedence:
+//
+//   ||
+//   &&
+//   = != < > <= >=
+//   + -
+//   * /
+//   ! - (unary)
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseBinary(0)
+}
+
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"=", "!=", "<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (p *parser) parseBinary(level int) (Expr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.typ != tokenPunct || !contains(precedence[level], tok.text) {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{op: tok.text, l: l, r: r}
+		if level == 2 {
+			// relational operators are not associative
+			return l, nil
+		}
+	}
+}
+
+func contains(ops []string, s string) bool {
+	for _, op := range ops {
+		if op == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	switch {
+	case p.accept("!"):
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	case p.accept("-"):
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{e}, nil
+	case p.accept("+"):
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	switch tok.typ {
+	case tokenPunct:
+		if tok.text == "(" {
+			return p.parseConstraint()
+		}
+	case tokenVar:
+		p.next()
+		return varExpr(tok.text), nil
+	case tokenKeyword:
+		if !isKeyword(tok, "true") && !isKeyword(tok, "false") {
+			return p.parseCall()
+		}
+	}
+	t, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	return termExpr{t}, nil
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	tok := p.next()
+	fn := strings.ToUpper(tok.text)
+	arity, ok := builtins[fn]
+	if !ok {
+		return nil, p.errorf(tok, "unknown function: %s", tok.text)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for !isPunct(p.peek(), ")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	p.next()
+
+	if (arity > 0 && len(args) != arity) || (arity < 0 && len(args) < -arity) {
+		return nil, p.errorf(tok, "wrong number of arguments to %s: %d", fn, len(args))
+	}
+	if fn == "BOUND" {
+		if _, ok := args[0].(varExpr); !ok {
+			return nil, p.errorf(tok, "BOUND requires a variable as argument")
+		}
+	}
+	return callExpr{fn: fn, args: args}, nil
+}
+
+// groupVars returns the named variables in the group, in order of appearance.
+func groupVars(g *Group, vars []rdf.Variable) []rdf.Variable {
+	add := func(q rdf.QVar) {
+		v, ok := q.(rdf.Variable)
+		if !ok || IsBlank(v) {
+			return
+		}
+		for _, seen := range vars {
+			if seen == v {
+				return
+			}
+		}
+		vars = append(vars, v)
+	}
+	for _, el := range g.Elements {
+		switch el := el.(type) {
+		case BGP:
+			for _, pat := range el {
+				add(pat.Subj)
+				add(pat.Pred)
+				add(pat.Obj)
+			}
+		case Optional:
+			vars = groupVars(el.Group, vars)
+		case Union:
+			for _, sub := range el {
+				vars = groupVars(sub, vars)
+			}
+		case *Group:
+			vars = groupVars(el, vars)
+		}
+	}
+	return vars
+}
@@ -0,0 +1,212 @@
+package sparql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Query
+	}{
+		{
+			`SELECT * WHERE { ?s ?p ?o }`,
+			Query{
+				Form:  Select,
+				Vars:  []rdf.Variable{"s", "p", "o"},
+				Where: &Group{Elements: []Element{BGP{{Subj: rdf.Variable("s"), Pred: rdf.Variable("p"), Obj: rdf.Variable("o")}}}},
+				Limit: -1,
+			},
+		},
+		{
+			`PREFIX foaf: <http://xmlns.com/foaf/0.1/>
+			 BASE <http://example.org/>
+			 select distinct ?name {
+				?x a foaf:Person ; foaf:name ?name, "x"@en .
+				<a> <b> 1, -2.5, true .
+			 } LIMIT 10 OFFSET 5`,
+			Query{
+				Form:     Select,
+				Distinct: true,
+				Vars:     []rdf.Variable{"name"},
+				Where: &Group{Elements: []Element{BGP{
+					{Subj: rdf.Variable("x"), Pred: rdf.RDFtype, Obj: rdf.URI("http://xmlns.com/foaf/0.1/Person")},
+					{Subj: rdf.Variable("x"), Pred: rdf.URI("http://xmlns.com/foaf/0.1/name"), Obj: rdf.Variable("name")},
+					{Subj: rdf.Variable("x"), Pred: rdf.URI("http://xmlns.com/foaf/0.1/name"), Obj: rdf.NewLangLiteral("x", "en")},
+					{Subj: rdf.URI("http://example.org/a"), Pred: rdf.URI("http://example.org/b"), Obj: rdf.NewTypedLiteral("1", rdf.XSDinteger)},
+					{Subj: rdf.URI("http://example.org/a"), Pred: rdf.URI("http://example.org/b"), Obj: rdf.NewTypedLiteral("-2.5", rdf.XSDdecimal)},
+					{Subj: rdf.URI("http://example.org/a"), Pred: rdf.URI("http://example.org/b"), Obj: rdf.NewTypedLiteral("true", rdf.XSDboolean)},
+				}}},
+				Limit:  10,
+				Offset: 5,
+			},
+		},
+		{
+			`ASK { { ?s <p> ?o } UNION { ?o <p> ?s } OPTIONAL { ?s <q> [] } FILTER (?s != ?o) }`,
+			Query{
+				Form: Ask,
+				Where: &Group{
+					Elements: []Element{
+						Union{
+							&Group{Elements: []Element{BGP{{Subj: rdf.Variable("s"), Pred: rdf.URI("p"), Obj: rdf.Variable("o")}}}},
+							&Group{Elements: []Element{BGP{{Subj: rdf.Variable("o"), Pred: rdf.URI("p"), Obj: rdf.Variable("s")}}}},
+						},
+						Optional{&Group{Elements: []Element{BGP{{Subj: rdf.Variable("s"), Pred: rdf.URI("q"), Obj: rdf.Variable("_:b1")}}}}},
+					},
+					Filters: []Expr{binaryExpr{"!=", varExpr("s"), varExpr("o")}},
+				},
+				Limit: -1,
+			},
+		},
+		{
+			`CONSTRUCT { ?s <knows> ?o } WHERE { ?o <knows> ?s } ORDER BY DESC(?s) ?o`,
+			Query{
+				Form:     Construct,
+				Template: []rdf.Pattern{{Subj: rdf.Variable("s"), Pred: rdf.URI("knows"), Obj: rdf.Variable("o")}},
+				Where:    &Group{Elements: []Element{BGP{{Subj: rdf.Variable("o"), Pred: rdf.URI("knows"), Obj: rdf.Variable("s")}}}},
+				OrderBy:  []OrderCondition{{Expr: varExpr("s"), Desc: true}, {Expr: varExpr("o")}},
+				Limit:    -1,
+			},
+		},
+		{
+			`DESCRIBE <a> ?x WHERE { ?x <p> "b" }`,
+			Query{
+				Form:      Describe,
+				Resources: []rdf.QVar{rdf.URI("a"), rdf.Variable("x")},
+				Where:     &Group{Elements: []Element{BGP{{Subj: rdf.Variable("x"), Pred: rdf.URI("p"), Obj: rdf.NewLiteral("b")}}}},
+				Limit:     -1,
+			},
+		},
+		{
+			`DESCRIBE <a>`,
+			Query{
+				Form:      Describe,
+				Resources: []rdf.QVar{rdf.URI("a")},
+				Limit:     -1,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("Parse(%q) =>\n%#v\nwant:\n%#v", test.input, *got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`SELECT`, `1:7 expected variable or '*', found EOF`},
+		{`SELECT ?x WHERE { ?x ?y }`, `1:25 expected variable, IRI or literal, found "}" (punctuation)`},
+		{`SELECT ?x { ?x a x:y }`, `1:18 undefined prefix: "x"`},
+		{"ASK {\n ?x <p> \"abc }", `2:9 unterminated string`},
+		{`ASK { FILTER(nofunc(?x)) }`, `1:14 unknown function: nofunc`},
+		{`ASK { } LIMIT x`, `1:15 expected integer, found "x" (keyword)`},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Parse(%q) => %v; want %s", test.input, err, test.want)
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	sol := Solution{
+		"n":    rdf.NewLiteral(int32(10)),
+		"name": rdf.NewLangLiteral("Anne", "en"),
+		"uri":  rdf.URI("http://example.org/anne"),
+		"s":    rdf.NewLiteral("abc"),
+		"b":    rdf.BlankNode("b0"),
+		"dt":   rdf.NewTypedLiteral("2016-02-29T23:59:01", rdf.XSDdateTime),
+		"d":    rdf.NewTypedLiteral("2016-02-29+02:00", rdf.XSDdate),
+		"nn":   rdf.NewTypedLiteral("7", rdf.XSDnonNegativeInteger),
+		"bad":  rdf.NewTypedLiteral("yesterday", rdf.XSDdate),
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`?n = 10`, true},
+		{`?n > 9.5 && ?n < 1e2`, true},
+		{`?n + 5 * 2 = 20`, true},
+		{`-?n = -10`, true},
+		{`?n / 4 = 2.5`, true},
+		{`!(?n != 10)`, true},
+		{`?s < "abd"`, true},
+		{`?s = "abc"`, true},
+		{`?name = "Anne"`, false},
+		{`STR(?name) = "Anne"`, true},
+		{`LANG(?name) = "en"`, true},
+		{`langMatches(LANG(?name), "*")`, true},
+		{`isIRI(?uri) && isLiteral(?n)`, true},
+		{`isBlank(?b) && !isBlank(?uri)`, true},
+		{`DATATYPE(?s) = <http://www.w3.org/2001/XMLSchema#string>`, true},
+		{`BOUND(?missing)`, false},
+		{`?missing = 1 || true`, true},
+		{`?missing = 1 && true`, false},
+		{`regex(?name, "^an", "i")`, true},
+		{`CONTAINS(UCASE(?s), "BC") && STRSTARTS(?s, "a") && STRENDS(?s, "c")`, true},
+		{`STRLEN(?s) = 3`, true},
+		{`sameTerm(?uri, <http://example.org/anne>)`, true},
+		{`?uri < 1`, false},
+		{`?dt > "2016-02-29T12:00:00Z"^^<http://www.w3.org/2001/XMLSchema#dateTimeStamp>`, true},
+		{`?dt = "2016-02-29T23:59:01.0"^^<http://www.w3.org/2001/XMLSchema#dateTime>`, true},
+		{`?d < "2016-03-01"^^<http://www.w3.org/2001/XMLSchema#date>`, true},
+		{`?d != "2016-02-29Z"^^<http://www.w3.org/2001/XMLSchema#date>`, true},
+		{`?bad < ?d || ?bad >= ?d`, false},
+		{`?nn > 5 && ?nn * 2 = 14`, true},
+	}
+
+	for _, test := range tests {
+		q, err := Parse("ASK { FILTER(" + test.expr + ") }")
+		if err != nil {
+			t.Errorf("parsing %q failed: %v", test.expr, err)
+			continue
+		}
+		if got := Holds(q.Where.Filters[0], sol); got != test.want {
+			t.Errorf("%s => %v; want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestModify(t *testing.T) {
+	q, err := Parse(`SELECT DISTINCT ?a WHERE { ?a ?b ?c } ORDER BY DESC(?a) OFFSET 1 LIMIT 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sols []Solution
+	for _, n := range []int32{3, 1, 4, 1, 5, 9, 2, 6} {
+		sols = append(sols, Solution{"a": rdf.NewLiteral(n), "b": rdf.URI("x")})
+	}
+	got := q.Modify(sols)
+	want := []Solution{{"a": rdf.NewLiteral(int32(6))}, {"a": rdf.NewLiteral(int32(5))}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Modify() => %v; want %v", got, want)
+	}
+
+	// Distinct blank nodes are distinct solutions.
+	q, err = Parse(`SELECT DISTINCT ?c WHERE { ?a ?b ?c }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sols = []Solution{{"c": rdf.BlankNode("b0")}, {"c": rdf.BlankNode("b1")}, {"c": rdf.BlankNode("b0")}}
+	got = q.Modify(sols)
+	want = []Solution{{"c": rdf.BlankNode("b0")}, {"c": rdf.BlankNode("b1")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Modify() => %v; want %v", got, want)
+	}
+}
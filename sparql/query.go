@@ -0,0 +1,219 @@
+// Package sparql implements a parser for SPARQL 1.1 queries, as well as
+// evaluation of expressions and solution modifiers. The evaluation of graph
+// patterns is left to the triple store.
+package sparql
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+// Form represents the form of a SPARQL query.
+type Form int
+
+// Available query forms.
+const (
+	Select Form = iota
+	Ask
+	Construct
+	Describe
+)
+
+func (f Form) String() string {
+	switch f {
+	case Select:
+		return "SELECT"
+	case Ask:
+		return "ASK"
+	case Construct:
+		return "CONSTRUCT"
+	case Describe:
+		return "DESCRIBE"
+	default:
+		return "unknown form"
+	}
+}
+
+// Query represents a parsed SPARQL query. All prefixed names and relative
+// IRIs are resolved to absolute URIs when parsing.
+type Query struct {
+	Form Form
+
+	// Distinct is true if duplicate solutions should be eliminated (SELECT).
+	Distinct bool
+
+	// Vars are the projected variables (SELECT). For SELECT * it holds all
+	// the variables in the WHERE clause, in order of appearance.
+	Vars []rdf.Variable
+
+	// Template is the graph template (CONSTRUCT).
+	Template []rdf.Pattern
+
+	// Resources are the URIs and variables to describe (DESCRIBE).
+	Resources []rdf.QVar
+
+	// Where is the graph pattern to match. It is nil if the query has no
+	// WHERE clause, which is only allowed for DESCRIBE.
+	Where *Group
+
+	// Solution modifiers
+	OrderBy []OrderCondition
+	Limit   int // -1 means no limit
+	Offset  int
+}
+
+// OrderCondition is an expression to order solutions by.
+type OrderCondition struct {
+	Expr Expr
+	Desc bool
+}
+
+// Group is a group graph pattern. The elements are joined in order, and the
+// filters apply to the whole group.
+type Group struct {
+	Elements []Element
+	Filters  []Expr
+}
+
+// Element is one of BGP, Optional, Union or *Group.
+type Element interface {
+	validAsElement()
+}
+
+// BGP is a basic graph pattern: a set of triple patterns.
+type BGP []rdf.Pattern
+
+// Optional is an OPTIONAL group graph pattern.
+type Optional struct {
+	*Group
+}
+
+// Union is a sequence of group graph patterns joined by UNION.
+type Union []*Group
+
+func (BGP) validAsElement()      {}
+func (Optional) validAsElement() {}
+func (Union) validAsElement()    {}
+func (*Group) validAsElement()   {}
+
+// Solution maps variable names to the RDF terms bound to them.
+type Solution map[string]rdf.Term
+
+// Results holds the results of a query. Which fields are set depend on the
+// query form: SELECT gives Vars and Solutions, ASK gives Boolean, and
+// CONSTRUCT and DESCRIBE gives Graph.
+type Results struct {
+	Form      Form
+	Vars      []string
+	Solutions []Solution
+	Boolean   bool
+	Graph     *rdf.Graph
+}
+
+// IsBlank reports whether the variable stands for a blank node in the query.
+// Blank nodes act as variables when matching, but are never projected.
+func IsBlank(v rdf.Variable) bool {
+	return strings.HasPrefix(string(v), "_:")
+}
+
+// Modify applies the solution modifiers of the query to the given solutions,
+// in this order: ORDER BY, projection, DISTINCT, OFFSET and LIMIT. Projection and
+// DISTINCT only applies to SELECT queries.
+func (q *Query) Modify(sols []Solution) []Solution {
+	if len(q.OrderBy) > 0 {
+		s := solutionSorter{sols: sols, conds: q.OrderBy, keys: make([][]rdf.Term, len(sols))}
+		for i, sol := range sols {
+			s.keys[i] = make([]rdf.Term, len(q.OrderBy))
+			for j, cond := range q.OrderBy {
+				s.keys[i][j], _ = cond.Expr.Eval(sol)
+			}
+		}
+		sort.Stable(s)
+	}
+
+	if q.Form == Select {
+		for i, sol := range sols {
+			proj := make(Solution, len(q.Vars))
+			for _, v := range q.Vars {
+				if t, ok := sol[string(v)]; ok {
+					proj[string(v)] = t
+				}
+			}
+			sols[i] = proj
+		}
+		if q.Distinct {
+			seen := make(map[string]bool)
+			uniq := sols[:0]
+			for _, sol := range sols {
+				k := q.solutionKey(sol)
+				if !seen[k] {
+					seen[k] = true
+					uniq = append(uniq, sol)
+				}
+			}
+			sols = uniq
+		}
+	}
+
+	if q.Offset > 0 {
+		if q.Offset >= len(sols) {
+			return sols[:0]
+		}
+		sols = sols[q.Offset:]
+	}
+	if q.Limit >= 0 && q.Limit < len(sols) {
+		sols = sols[:q.Limit]
+	}
+	return sols
+}
+
+// solutionKey returns a string uniquely identifying the projected solution.
+func (q *Query) solutionKey(sol Solution) string {
+	var b bytes.Buffer
+	for _, v := range q.Vars {
+		switch t := sol[string(v)].(type) {
+		case nil:
+			b.WriteString("\x00")
+		case rdf.URI:
+			b.WriteString("<" + string(t) + ">")
+		case rdf.BlankNode:
+			b.WriteString("_:" + string(t))
+		case rdf.Literal:
+			b.WriteString(t.String() + "\x01" + t.Lang() + "\x01" + string(t.DataType()))
+		}
+		b.WriteString("\x02")
+	}
+	return b.String()
+}
+
+// solutionSorter sorts solutions by the values of the order conditions,
+// which are evaluated in advance for each solution.
+type solutionSorter struct {
+	sols  []Solution
+	conds []OrderCondition
+	keys  [][]rdf.Term
+}
+
+func (s solutionSorter) Len() int { return len(s.sols) }
+
+func (s solutionSorter) Swap(i, j int) {
+	s.sols[i], s.sols[j] = s.sols[j], s.sols[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s solutionSorter) Less(i, j int) bool {
+	for n, cond := range s.conds {
+		c := orderCompare(s.keys[i][n], s.keys[j][n])
+		if c == 0 {
+			continue
+		}
+		if cond.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package sparql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+// Media types of the supported result formats.
+const (
+	MediaTypeJSON = "application/sparql-results+json"
+	MediaTypeXML  = "application/sparql-results+xml"
+	MediaTypeCSV  = "text/csv"
+	MediaTypeTSV  = "text/tab-separated-values"
+)
+
+// errNotSolutions is returned when trying to write the results of a
+// CONSTRUCT or DESCRIBE query in a format for solutions.
+var errNotSolutions = errors.New("query results is a graph, not solutions")
+
+// jsonTerm is the SPARQL JSON representation of a RDF term.
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+// WriteJSON writes the results in the SPARQL 1.1 Query Results JSON Format.
+func (r *Results) WriteJSON(w io.Writer) error {
+	var doc struct {
+		Head struct {
+			Vars []string `json:"vars,omitempty"`
+		} `json:"head"`
+		Boolean *bool `json:"boolean,omitempty"`
+		Results *struct {
+			Bindings []map[string]jsonTerm `json:"bindings"`
+		} `json:"results,omitempty"`
+	}
+
+	switch r.Form {
+	case Ask:
+		doc.Boolean = &r.Boolean
+	case Select:
+		doc.Head.Vars = r.Vars
+		doc.Results = &struct {
+			Bindings []map[string]jsonTerm `json:"bindings"`
+		}{Bindings: make([]map[string]jsonTerm, 0, len(r.Solutions))}
+		for _, sol := range r.Solutions {
+			b := make(map[string]jsonTerm, len(sol))
+			for v, t := range sol {
+				switch t := t.(type) {
+				case rdf.URI:
+					b[v] = jsonTerm{Type: "uri", Value: string(t)}
+				case rdf.BlankNode:
+					b[v] = jsonTerm{Type: "bnode", Value: string(t)}
+				case rdf.Literal:
+					b[v] = jsonTerm{Type: "literal", Value: t.String(), Lang: t.Lang(), Datatype: datatype(t)}
+				}
+			}
+			doc.Results.Bindings = append(doc.Results.Bindings, b)
+		}
+	default:
+		return errNotSolutions
+	}
+
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// WriteXML writes the results in the SPARQL Query Results XML Format.
+func (r *Results) WriteXML(w io.Writer) error {
+	if r.Form != Select && r.Form != Ask {
+		return errNotSolutions
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString("<sparql xmlns=\"http://www.w3.org/2005/sparql-results#\">\n<head>\n")
+	for _, v := range r.Vars {
+		fmt.Fprintf(bw, "\t<variable name=\"%s\"/>\n", escapeXML(v))
+	}
+	bw.WriteString("</head>\n")
+
+	if r.Form == Ask {
+		fmt.Fprintf(bw, "<boolean>%v</boolean>\n</sparql>\n", r.Boolean)
+		return bw.Flush()
+	}
+
+	bw.WriteString("<results>\n")
+	for _, sol := range r.Solutions {
+		bw.WriteString("\t<result>\n")
+		for _, v := range r.Vars {
+			t, ok := sol[v]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(bw, "\t\t<binding name=\"%s\">", escapeXML(v))
+			switch t := t.(type) {
+			case rdf.URI:
+				fmt.Fprintf(bw, "<uri>%s</uri>", escapeXML(string(t)))
+			case rdf.BlankNode:
+				fmt.Fprintf(bw, "<bnode>%s</bnode>", escapeXML(string(t)))
+			case rdf.Literal:
+				bw.WriteString("<literal")
+				if t.Lang() != "" {
+					fmt.Fprintf(bw, " xml:lang=\"%s\"", escapeXML(t.Lang()))
+				} else if dt := datatype(t); dt != "" {
+					fmt.Fprintf(bw, " datatype=\"%s\"", escapeXML(dt))
+				}
+				fmt.Fprintf(bw, ">%s</literal>", escapeXML(t.String()))
+			}
+			bw.WriteString("</binding>\n")
+		}
+		bw.WriteString("\t</result>\n")
+	}
+	bw.WriteString("</results>\n</sparql>\n")
+	return bw.Flush()
+}
+
+// WriteCSV writes the results in the SPARQL 1.1 Query Results CSV Format.
+// Only the lexical forms of the terms are written.
+func (r *Results) WriteCSV(w io.Writer) error {
+	if r.Form != Select && r.Form != Ask {
+		return errNotSolutions
+	}
+	if r.Form == Ask {
+		// Not defined by the specification, but useful for clients.
+		_, err := fmt.Fprintf(w, "%v\r\n", r.Boolean)
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(r.Vars); err != nil {
+		return err
+	}
+	row := make([]string, len(r.Vars))
+	for _, sol := range r.Solutions {
+		for i, v := range r.Vars {
+			switch t := sol[v].(type) {
+			case nil:
+				row[i] = ""
+			case rdf.BlankNode:
+				row[i] = "_:" + t.String()
+			default:
+				row[i] = t.String()
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTSV writes the results in the SPARQL 1.1 Query Results TSV Format.
+// The terms are written using Turtle syntax.
+func (r *Results) WriteTSV(w io.Writer) error {
+	if r.Form != Select && r.Form != Ask {
+		return errNotSolutions
+	}
+	if r.Form == Ask {
+		// Not defined by the specification, but useful for clients.
+		_, err := fmt.Fprintf(w, "%v\n", r.Boolean)
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, v := range r.Vars {
+		if i > 0 {
+			bw.WriteRune('\t')
+		}
+		bw.WriteString("?" + v)
+	}
+	bw.WriteRune('\n')
+	for _, sol := range r.Solutions {
+		for i, v := range r.Vars {
+			if i > 0 {
+				bw.WriteRune('\t')
+			}
+			switch t := sol[v].(type) {
+			case rdf.URI:
+				fmt.Fprintf(bw, "<%s>", t)
+			case rdf.BlankNode:
+				fmt.Fprintf(bw, "_:%s", t)
+			case rdf.Literal:
+				switch t.DataType() {
+				case rdf.RDFlangString:
+					fmt.Fprintf(bw, "%q@%s", t.String(), t.Lang())
+				case rdf.XSDstring:
+					fmt.Fprintf(bw, "%q", t.String())
+				default:
+					fmt.Fprintf(bw, "%q^^<%s>", t.String(), t.DataType())
+				}
+			}
+		}
+		bw.WriteRune('\n')
+	}
+	return bw.Flush()
+}
+
+// datatype returns the datatype URI of a literal as it should be written
+// in results. Simple and language tagged literals have none.
+func datatype(l rdf.Literal) string {
+	switch l.DataType() {
+	case rdf.XSDstring, rdf.RDFlangString:
+		return ""
+	}
+	return string(l.DataType())
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package sparql
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+func TestWriteResults(t *testing.T) {
+	res := &Results{
+		Form: Select,
+		Vars: []string{"s", "o"},
+		Solutions: []Solution{
+			{"s": rdf.URI("http://example.org/a"), "o": rdf.NewLangLiteral("x, \"y\"", "en")},
+			{"s": rdf.URI("http://example.org/b"), "o": rdf.NewLiteral(int32(1))},
+			{"s": rdf.URI("http://example.org/c")},
+			{"s": rdf.BlankNode("b0"), "o": rdf.BlankNode("b1")},
+		},
+	}
+
+	tests := []struct {
+		write func(*Results, *bytes.Buffer) error
+		want  string
+	}{
+		{
+			func(r *Results, b *bytes.Buffer) error { return r.WriteJSON(b) },
+			`{"head":{"vars":["s","o"]},"results":{"bindings":[` +
+				`{"o":{"type":"literal","value":"x, \"y\"","xml:lang":"en"},"s":{"type":"uri","value":"http://example.org/a"}},` +
+				`{"o":{"type":"literal","value":"1","datatype":"http://www.w3.org/2001/XMLSchema#int"},"s":{"type":"uri","value":"http://example.org/b"}},` +
+				`{"s":{"type":"uri","value":"http://example.org/c"}},` +
+				`{"o":{"type":"bnode","value":"b1"},"s":{"type":"bnode","value":"b0"}}]}}` + "\n",
+		},
+		{
+			func(r *Results, b *bytes.Buffer) error { return r.WriteXML(b) },
+			`<?xml version="1.0" encoding="UTF-8"?>
+<sparql xmlns="http://www.w3.org/2005/sparql-results#">
+<head>
+	<variable name="s"/>
+	<variable name="o"/>
+</head>
+<results>
+	<result>
+		<binding name="s"><uri>http://example.org/a</uri></binding>
+		<binding name="o"><literal xml:lang="en">x, &#34;y&#34;</literal></binding>
+	</result>
+	<result>
+		<binding name="s"><uri>http://example.org/b</uri></binding>
+		<binding name="o"><literal datatype="http://www.w3.org/2001/XMLSchema#int">1</literal></binding>
+	</result>
+	<result>
+		<binding name="s"><uri>http://example.org/c</uri></binding>
+	</result>
+	<result>
+		<binding name="s"><bnode>b0</bnode></binding>
+		<binding name="o"><bnode>b1</bnode></binding>
+	</result>
+</results>
+</sparql>
+`,
+		},
+		{
+			func(r *Results, b *bytes.Buffer) error { return r.WriteCSV(b) },
+			"s,o\r\nhttp://example.org/a,\"x, \"\"y\"\"\"\r\nhttp://example.org/b,1\r\nhttp://example.org/c,\r\n_:b0,_:b1\r\n",
+		},
+		{
+			func(r *Results, b *bytes.Buffer) error { return r.WriteTSV(b) },
+			"?s\t?o\n<http://example.org/a>\t\"x, \\\"y\\\"\"@en\n" +
+				"<http://example.org/b>\t\"1\"^^<http://www.w3.org/2001/XMLSchema#int>\n<http://example.org/c>\t\n_:b0\t_:b1\n",
+		},
+	}
+
+	for i, test := range tests {
+		var b bytes.Buffer
+		if err := test.write(res, &b); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%d: got:\n%s\nwant:\n%s", i, got, test.want)
+		}
+	}
+
+	ask := &Results{Form: Ask, Boolean: true}
+	var b bytes.Buffer
+	if err := ask.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"head":{},"boolean":true}` + "\n"; b.String() != want {
+		t.Errorf("got %s; want %s", b.String(), want)
+	}
+
+	graph := &Results{Form: Construct, Graph: rdf.NewGraph()}
+	if err := graph.WriteJSON(&b); err != errNotSolutions {
+		t.Errorf("writing graph results as JSON => %v; want %v", err, errNotSolutions)
+	}
+}
@@ -0,0 +1,146 @@
+package sparql
+
+import (
+	"github.com/boutros/sopp/rdf"
+)
+
+// Update represents a parsed SPARQL update request, which is a sequence
+// of operations to be performed in order.
+type Update struct {
+	Operations []Operation
+}
+
+// Operation is a single update operation. INSERT DATA and DELETE DATA have
+// no Where clause, and contain no variables.
+//
+// For DELETE/INSERT WHERE, the Delete and Insert templates are instantiated
+// for every solution to Where; all the deletions are performed before the
+// insertions.
+type Operation struct {
+	Delete []rdf.Pattern
+	Insert []rdf.Pattern
+	Where  *Group
+}
+
+// ParseUpdate parses a SPARQL update request.
+func ParseUpdate(update string) (*Update, error) {
+	p, err := newParser(update)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseUpdate()
+}
+
+func (p *parser) parseUpdate() (*Update, error) {
+	u := &Update{}
+	for {
+		if err := p.parsePrologue(); err != nil {
+			return nil, err
+		}
+		if tok := p.peek(); tok.typ == tokenEOF {
+			if len(u.Operations) == 0 {
+				return nil, p.errorExpected("INSERT|DELETE", tok)
+			}
+			return u, nil
+		}
+
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		u.Operations = append(u.Operations, op)
+
+		if !p.accept(";") {
+			if tok := p.peek(); tok.typ != tokenEOF {
+				return nil, p.errorExpected("';' or EOF", tok)
+			}
+			return u, nil
+		}
+	}
+}
+
+func (p *parser) parseOperation() (Operation, error) {
+	var op Operation
+	var err error
+	tok := p.next()
+	switch {
+	case isKeyword(tok, "INSERT") && p.accept("DATA"):
+		op.Insert, err = p.parseData()
+		return op, err
+	case isKeyword(tok, "DELETE") && p.accept("DATA"):
+		op.Delete, err = p.parseData()
+		return op, err
+	case isKeyword(tok, "DELETE") && p.accept("WHERE"):
+		// DELETE WHERE { ... } is short for using the basic graph pattern
+		// both as template and in the WHERE clause.
+		if op.Delete, err = p.parseTemplate(); err != nil {
+			return op, err
+		}
+		op.Where = &Group{Elements: []Element{BGP(op.Delete)}}
+		return op, nil
+	case isKeyword(tok, "DELETE"):
+		if op.Delete, err = p.parseTemplate(); err != nil {
+			return op, err
+		}
+		if p.accept("INSERT") {
+			if op.Insert, err = p.parseTemplate(); err != nil {
+				return op, err
+			}
+		}
+	case isKeyword(tok, "INSERT"):
+		if op.Insert, err = p.parseTemplate(); err != nil {
+			return op, err
+		}
+	case isKeyword(tok, "LOAD"), isKeyword(tok, "CLEAR"), isKeyword(tok, "CREATE"), isKeyword(tok, "DROP"),
+		isKeyword(tok, "COPY"), isKeyword(tok, "MOVE"), isKeyword(tok, "ADD"), isKeyword(tok, "WITH"):
+		return op, p.errorf(tok, "unsupported update operation: %s", tok.text)
+	default:
+		return op, p.errorExpected("INSERT|DELETE", tok)
+	}
+
+	if !isKeyword(p.peek(), "WHERE") {
+		return op, p.errorExpected("WHERE", p.peek())
+	}
+	op.Where, err = p.parseWhere()
+	return op, err
+}
+
+// parseTemplate parses a bracketed block of triple patterns.
+func (p *parser) parseTemplate() ([]rdf.Pattern, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	start := p.peek()
+	pats, err := p.parseTriples(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	for _, pat := range pats {
+		for _, q := range []rdf.QVar{pat.Subj, pat.Pred, pat.Obj} {
+			if v, ok := q.(rdf.Variable); ok && IsBlank(v) {
+				return nil, p.errorf(start, "blank nodes are not supported in update templates")
+			}
+		}
+	}
+	return pats, nil
+}
+
+// parseData parses a bracketed block of triples without variables.
+func (p *parser) parseData() ([]rdf.Pattern, error) {
+	start := p.peek()
+	pats, err := p.parseTemplate()
+	if err != nil {
+		return nil, err
+	}
+	for _, pat := range pats {
+		for _, q := range []rdf.QVar{pat.Subj, pat.Pred, pat.Obj} {
+			if _, ok := q.(rdf.Variable); ok {
+				return nil, p.errorf(start, "variables are not allowed in DATA")
+			}
+		}
+	}
+	return pats, nil
+}
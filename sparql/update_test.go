@@ -0,0 +1,79 @@
+package sparql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+)
+
+func TestParseUpdate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Operation
+	}{
+		{
+			`PREFIX : <http://example.org/>
+			 INSERT DATA { :a :b "c" } ;
+			 DELETE DATA { :a :b :d }`,
+			[]Operation{
+				{Insert: []rdf.Pattern{{Subj: rdf.URI("http://example.org/a"), Pred: rdf.URI("http://example.org/b"), Obj: rdf.NewLiteral("c")}}},
+				{Delete: []rdf.Pattern{{Subj: rdf.URI("http://example.org/a"), Pred: rdf.URI("http://example.org/b"), Obj: rdf.URI("http://example.org/d")}}},
+			},
+		},
+		{
+			`DELETE WHERE { ?s <p> ?o }`,
+			[]Operation{
+				{
+					Delete: []rdf.Pattern{{Subj: rdf.Variable("s"), Pred: rdf.URI("p"), Obj: rdf.Variable("o")}},
+					Where:  &Group{Elements: []Element{BGP{{Subj: rdf.Variable("s"), Pred: rdf.URI("p"), Obj: rdf.Variable("o")}}}},
+				},
+			},
+		},
+		{
+			`DELETE { ?s <p> ?o } INSERT { ?s <q> ?o } WHERE { ?s <p> ?o FILTER(?o > 1) }`,
+			[]Operation{
+				{
+					Delete: []rdf.Pattern{{Subj: rdf.Variable("s"), Pred: rdf.URI("p"), Obj: rdf.Variable("o")}},
+					Insert: []rdf.Pattern{{Subj: rdf.Variable("s"), Pred: rdf.URI("q"), Obj: rdf.Variable("o")}},
+					Where: &Group{
+						Elements: []Element{BGP{{Subj: rdf.Variable("s"), Pred: rdf.URI("p"), Obj: rdf.Variable("o")}}},
+						Filters:  []Expr{binaryExpr{">", varExpr("o"), termExpr{rdf.NewTypedLiteral("1", rdf.XSDinteger)}}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseUpdate(test.input)
+		if err != nil {
+			t.Errorf("ParseUpdate(%q) failed: %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Operations, test.want) {
+			t.Errorf("ParseUpdate(%q) =>\n%#v\nwant:\n%#v", test.input, got.Operations, test.want)
+		}
+	}
+}
+
+func TestParseUpdateErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{``, `1:1 expected INSERT|DELETE, found EOF`},
+		{`INSERT DATA { ?s <p> <o> }`, `1:13 variables are not allowed in DATA`},
+		{`INSERT { _:b <p> ?o } WHERE { ?s <p> ?o }`, `1:10 blank nodes are not supported in update templates`},
+		{`INSERT { ?s <p> ?o }`, `1:21 expected WHERE, found EOF`},
+		{`CLEAR ALL`, `1:1 unsupported update operation: CLEAR`},
+		{`INSERT DATA { <s> <p> <o> } INSERT DATA { <s> <p> <o> }`, `1:29 expected ';' or EOF, found "INSERT" (keyword)`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseUpdate(test.input)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("ParseUpdate(%q) => %v; want %s", test.input, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package sopp
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boutros/sopp/rdf"
+	"github.com/boutros/sopp/sparql"
+)
+
+const sparqlTestData = `
+@base <http://test.org/> .
+@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+<anne> a <Person> ; <name> "Anne" ; <born> "1950"^^xsd:int ; <knows> <bob>, <carl> .
+<bob> a <Person> ; <name> "Bob" ; <born> "1890"^^xsd:int ; <knows> <anne> .
+<carl> a <Person> ; <name> "Carl"@en ; <knows> <carl> .
+<dog> a <Animal> ; <name> "Fido" .
+`
+
+// solutionsString returns a comparable representation of query solutions.
+// If ordered is false, the solutions are sorted.
+func solutionsString(sols []sparql.Solution, ordered bool) string {
+	res := make([]string, len(sols))
+	for i, sol := range sols {
+		res[i] = rowsString([]Bindings{Bindings(sol)})
+	}
+	if !ordered {
+		sort.Strings(res)
+	}
+	return strings.Join(res, "\n")
+}
+
+func TestSparqlSelect(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if _, err := db.Import(bytes.NewBufferString(sparqlTestData), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query   string
+		ordered bool
+		want    []string
+	}{
+		{
+			`SELECT ?s WHERE { ?s a <http://test.org/Person> ; <http://test.org/born> ?y FILTER(?y > 1900) }`,
+			false,
+			[]string{"s=http://test.org/anne"},
+		},
+		{
+			`BASE <http://test.org/>
+			 SELECT ?s ?y WHERE { ?s a <Person> OPTIONAL { ?s <born> ?y } }`,
+			false,
+			[]string{
+				"s=http://test.org/anne y=1950",
+				"s=http://test.org/bob y=1890",
+				"s=http://test.org/carl",
+			},
+		},
+		{
+			`BASE <http://test.org/>
+			 SELECT ?s WHERE { ?s a <Person> OPTIONAL { ?s <born> ?y } FILTER(!BOUND(?y)) }`,
+			false,
+			[]string{"s=http://test.org/carl"},
+		},
+		{
+			`BASE <http://test.org/>
+			 SELECT ?n WHERE { { ?s a <Animal> } UNION { ?s <born> ?y FILTER(?y < 1900) } ?s <name> ?n }`,
+			false,
+			[]string{"n=Bob", "n=Fido"},
+		},
+		{
+			`PREFIX t: <http://test.org/>
+			 SELECT ?n WHERE { ?s t:name ?n } ORDER BY DESC(STR(?n)) LIMIT 2 OFFSET 1`,
+			true,
+			[]string{"n=Carl", "n=Bob"},
+		},
+		{
+			`PREFIX t: <http://test.org/>
+			 SELECT DISTINCT ?s WHERE { ?s t:knows ?o } ORDER BY ?s`,
+			true,
+			[]string{
+				"s=http://test.org/anne",
+				"s=http://test.org/bob",
+				"s=http://test.org/carl",
+			},
+		},
+		{
+			`SELECT * WHERE { ?s <http://test.org/missing> ?o }`,
+			false,
+			nil,
+		},
+		{
+			`SELECT ?s WHERE { ?s a ?c } LIMIT 0`,
+			false,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		res, err := db.Sparql(test.query)
+		if err != nil {
+			t.Errorf("DB.Sparql(%q) failed: %v", test.query, err)
+			continue
+		}
+		if got, want := solutionsString(res.Solutions, test.ordered), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("DB.Sparql(%q) =>\n%s\nwant:\n%s", test.query, got, want)
+		}
+	}
+}
+
+func TestSparqlAskConstructDescribe(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if _, err := db.Import(bytes.NewBufferString(sparqlTestData), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range map[string]bool{
+		`ASK { <http://test.org/anne> <http://test.org/knows> ?x }`: true,
+		`ASK { <http://test.org/dog> <http://test.org/knows> ?x }`:  false,
+	} {
+		res, err := db.Sparql(query)
+		if err != nil {
+			t.Fatalf("DB.Sparql(%q) failed: %v", query, err)
+		}
+		if res.Boolean != want {
+			t.Errorf("DB.Sparql(%q) => %v; want %v", query, res.Boolean, want)
+		}
+	}
+
+	graphTests := []struct {
+		query string
+		want  string
+	}{
+		{
+			`BASE <http://test.org/>
+			 CONSTRUCT { ?o <knownBy> ?s } WHERE { ?s <knows> ?o FILTER(?s != ?o) }`,
+			`<http://test.org/bob> <http://test.org/knownBy> <http://test.org/anne> .
+			 <http://test.org/carl> <http://test.org/knownBy> <http://test.org/anne> .
+			 <http://test.org/anne> <http://test.org/knownBy> <http://test.org/bob> .`,
+		},
+		{
+			`DESCRIBE <http://test.org/dog>`,
+			`<http://test.org/dog> a <http://test.org/Animal> ; <http://test.org/name> "Fido" .`,
+		},
+		{
+			`BASE <http://test.org/>
+			 DESCRIBE ?s WHERE { ?s <name> "Fido" }`,
+			`<http://test.org/dog> a <http://test.org/Animal> ; <http://test.org/name> "Fido" .`,
+		},
+	}
+
+	for _, test := range graphTests {
+		res, err := db.Sparql(test.query)
+		if err != nil {
+			t.Fatalf("DB.Sparql(%q) failed: %v", test.query, err)
+		}
+		want, err := rdf.NewDecoder(bytes.NewBufferString(test.want)).DecodeGraph()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Graph.Eq(want) {
+			t.Errorf("DB.Sparql(%q) =>\n%v\nwant:\n%v", test.query, res.Graph.Triples(), want.Triples())
+		}
+	}
+
+	// Blank nodes in the template are new blank nodes for each solution.
+	q := `BASE <http://test.org/>
+	      CONSTRUCT { ?s <nameNode> _:n . _:n <value> ?name } WHERE { ?s a <Person> ; <name> ?name }`
+	res, err := db.Sparql(q)
+	if err != nil {
+		t.Fatalf("DB.Sparql(%q) failed: %v", q, err)
+	}
+	nodes := make(map[rdf.Term]bool)
+	for _, s := range []string{"anne", "bob", "carl"} {
+		g := res.Graph.Describe(rdf.URI("http://test.org/"+s), false)
+		for _, tr := range g.Triples() {
+			if _, ok := tr.Obj.(rdf.BlankNode); ok {
+				nodes[tr.Obj] = true
+			}
+		}
+	}
+	if res.Graph.Size() != 6 || len(nodes) != 3 {
+		t.Errorf("DB.Sparql(%q) =>\n%v\nwant 3 distinct blank nodes, each with a value", q, res.Graph.Triples())
+	}
+}
+
+func TestSparqlUpdate(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if _, err := db.Import(bytes.NewBufferString(sparqlTestData), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := []string{
+		`PREFIX t: <http://test.org/>
+		 INSERT DATA { t:dog t:owner t:anne } ;
+		 DELETE DATA { t:carl t:knows t:carl }`,
+		`PREFIX t: <http://test.org/>
+		 DELETE { ?s t:born ?y } INSERT { ?s t:bornBefore1900 true } WHERE { ?s t:born ?y FILTER(?y < 1900) }`,
+		`DELETE WHERE { <http://test.org/dog> <http://test.org/name> ?n }`,
+	}
+	for _, u := range updates {
+		if err := db.SparqlUpdate(u); err != nil {
+			t.Fatalf("DB.SparqlUpdate(%q) failed: %v", u, err)
+		}
+	}
+
+	// Verify that a failing request is rolled back entirely.
+	if err := db.SparqlUpdate(`INSERT DATA { <http://test.org/x> <http://test.org/y> <http://test.org/z> } ;
+		INSERT DATA { "not a subject" <http://test.org/y> <http://test.org/z> }`); err == nil {
+		t.Fatal("DB.SparqlUpdate with invalid triple succeeded")
+	}
+
+	want, err := rdf.NewDecoder(bytes.NewBufferString(`
+@base <http://test.org/> .
+@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+<anne> a <Person> ; <name> "Anne" ; <born> "1950"^^xsd:int ; <knows> <bob>, <carl> .
+<bob> a <Person> ; <name> "Bob" ; <bornBefore1900> true ; <knows> <anne> .
+<carl> a <Person> ; <name> "Carl"@en .
+<dog> a <Animal> ; <owner> <anne> .
+`)).DecodeGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Construct(rdf.Pattern{Subj: rdf.Any, Pred: rdf.Any, Obj: rdf.Any})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Eq(want) {
+		t.Errorf("after updates got:\n%v\nwant:\n%v", got.Triples(), want.Triples())
+	}
+}
@@ -0,0 +1,278 @@
+package sopp
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/boutros/sopp/rdf"
+)
+
+// Tx is a transaction on the triple store. All the operations of a
+// transaction see the same snapshot of the database, and the changes
+// made in a read-write transaction are committed together, or not at all.
+//
+// A Tx is only valid within the function given to DB.Update or DB.View,
+// and must not be used from several goroutines at once.
+type Tx struct {
+	db *DB
+	tx *bolt.Tx
+}
+
+// Update executes fn within a read-write transaction. If fn returns an
+// error, the transaction is rolled back and the error is returned.
+// Only one read-write transaction can be open at a time.
+func (db *DB) Update(fn func(*Tx) error) error {
+	return db.kv.Update(func(tx *bolt.Tx) error {
+		return fn(&Tx{db: db, tx: tx})
+	})
+}
+
+// View executes fn within a read-only transaction. Any attempt to modify
+// the store from a read-only transaction fails with bolt.ErrTxNotWritable.
+func (db *DB) View(fn func(*Tx) error) error {
+	return db.kv.View(func(tx *bolt.Tx) error {
+		return fn(&Tx{db: db, tx: tx})
+	})
+}
+
+// Insert stores the given Triple in the default graph.
+func (tx *Tx) Insert(tr rdf.Triple) error {
+	return tx.db.insert(tx.tx, 0, tr)
+}
+
+// InsertIn stores the given Triple in the named graph g. The graph
+// is created if it does not exist.
+func (tx *Tx) InsertIn(g rdf.URI, tr rdf.Triple) error {
+	gID, err := tx.db.createGraph(tx.tx, g)
+	if err != nil {
+		return err
+	}
+	return tx.db.insert(tx.tx, gID, tr)
+}
+
+// Delete removes the given Triple from the default graph, and any Term
+// unique to that Triple from the store.
+// It return ErrNotFound if the Triple is not stored.
+func (tx *Tx) Delete(tr rdf.Triple) error {
+	return tx.db.delete(tx.tx, 0, tr)
+}
+
+// DeleteFrom removes the given Triple from the named graph g.
+// It return ErrNotFound if the Triple is not stored in the graph.
+func (tx *Tx) DeleteFrom(g rdf.URI, tr rdf.Triple) error {
+	gID, err := tx.db.getID(tx.tx, g)
+	if err != nil {
+		return err
+	}
+	return tx.db.delete(tx.tx, gID, tr)
+}
+
+// Has checks if the given Triple is stored in the default graph.
+func (tx *Tx) Has(tr rdf.Triple) (bool, error) {
+	return tx.db.has(tx.tx, 0, tr)
+}
+
+// HasIn checks if the given Triple is stored in the named graph g.
+func (tx *Tx) HasIn(g rdf.URI, tr rdf.Triple) (bool, error) {
+	gID, err := tx.db.getID(tx.tx, g)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return tx.db.has(tx.tx, gID, tr)
+}
+
+// Describe returns a graph with all the triples in the default graph where
+// the given node is subject. If asObject is true, it also includes the triples
+// where the node is object.
+func (tx *Tx) Describe(node rdf.Subject, asObject bool) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	return g, tx.db.describe(tx.tx, 0, node, asObject, g)
+}
+
+// DescribeIn is like Describe, but only considers the triples stored
+// in the named graph name.
+func (tx *Tx) DescribeIn(name rdf.URI, node rdf.Subject, asObject bool) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	gID, err := tx.db.getID(tx.tx, name)
+	if err == ErrNotFound {
+		return g, nil
+	} else if err != nil {
+		return g, err
+	}
+	return g, tx.db.describe(tx.tx, gID, node, asObject, g)
+}
+
+// Construct returns a graph with all the stored triples matching the given
+// pattern. Any position of the pattern which is not an RDF Term (ex: rdf.Any)
+// matches every term.
+func (tx *Tx) Construct(p rdf.Pattern) (*rdf.Graph, error) {
+	g := rdf.NewGraph()
+	err := tx.Match(p, func(tr rdf.Triple) error {
+		g.Insert(tr)
+		return nil
+	})
+	return g, err
+}
+
+// Match calls fn for every stored triple matching the given pattern. If fn
+// returns an error, the iteration stops and the error is returned.
+//
+// The store must not be modified by fn; collect the triples and modify
+// the store when Match returns.
+func (tx *Tx) Match(p rdf.Pattern, fn func(rdf.Triple) error) error {
+	db := tx.db
+	ids, ok, err := db.patternIDs(tx.tx, p)
+	if err != nil || !ok {
+		return err
+	}
+
+	return db.matchIDs(tx.tx, ids[0], ids[1], ids[2], func(s, p, o uint32) error {
+		var tr rdf.Triple
+		var term rdf.Term
+		var err error
+
+		if term, err = db.getTerm(tx.tx, s); err != nil {
+			return err
+		}
+		tr.Subj = term.(rdf.Subject)
+		if tr.Pred, err = db.getPred(tx.tx, p); err != nil {
+			return err
+		}
+		if tr.Obj, err = db.getTerm(tx.tx, o); err != nil {
+			return err
+		}
+		return fn(tr)
+	})
+}
+
+// DeleteMatching removes all the triples in the default graph matching the
+// given pattern, and returns the number of triples removed. Terms which are
+// no longer part of any triple are removed from the store.
+func (tx *Tx) DeleteMatching(p rdf.Pattern) (int, error) {
+	ids, ok, err := tx.db.patternIDs(tx.tx, p)
+	if err != nil || !ok {
+		return 0, err
+	}
+	var trs [][3]uint32
+	if err := tx.db.matchIDs(tx.tx, ids[0], ids[1], ids[2], func(s, p, o uint32) error {
+		trs = append(trs, [3]uint32{s, p, o})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return tx.db.removeTriples(tx.tx, 0, trs)
+}
+
+// DeleteResource removes all the triples in the default graph where the given
+// URI is subject, and returns the number of triples removed. If includeIncoming
+// is true, the triples where it is object are removed as well.
+func (tx *Tx) DeleteResource(uri rdf.URI, includeIncoming bool) (int, error) {
+	id, err := tx.db.getID(tx.tx, uri)
+	if err == ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var trs [][3]uint32
+	if err := tx.db.matchIDs(tx.tx, id, 0, 0, func(s, p, o uint32) error {
+		trs = append(trs, [3]uint32{s, p, o})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if includeIncoming {
+		if err := tx.db.matchIDs(tx.tx, 0, 0, id, func(s, p, o uint32) error {
+			if s != id {
+				// Triples with the URI as subject are allready collected
+				trs = append(trs, [3]uint32{s, p, o})
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return tx.db.removeTriples(tx.tx, 0, trs)
+}
+
+// Replace replaces the description of node in the default graph, as returned
+// by Describe(node, false), with the triples of g. Only the triples not
+// allready stored are inserted, and only those missing from g are removed.
+// All the triples of g must have node as subject.
+func (tx *Tx) Replace(node rdf.URI, g *rdf.Graph) (Diff, error) {
+	diff := Diff{Removed: rdf.NewGraph(), Added: rdf.NewGraph()}
+	for _, tr := range g.Triples() {
+		if tr.Subj != node {
+			return diff, fmt.Errorf("cannot replace %v: triple with another subject: %v", node, tr)
+		}
+	}
+	old, err := tx.Describe(node, false)
+	if err != nil {
+		return diff, err
+	}
+	for _, tr := range g.Triples() {
+		if !old.Has(tr) {
+			diff.Added.Insert(tr)
+		}
+	}
+	for _, tr := range old.Triples() {
+		if !g.Has(tr) {
+			diff.Removed.Insert(tr)
+		}
+	}
+
+	// Insert before removing, so that terms in both are kept
+	for _, tr := range diff.Added.Triples() {
+		if err := tx.Insert(tr); err != nil {
+			return diff, err
+		}
+	}
+	for _, tr := range diff.Removed.Triples() {
+		if err := tx.Delete(tr); err != nil {
+			return diff, err
+		}
+	}
+	return diff, nil
+}
+
+// RenameURI changes the URI old to new in all the triples where it occurs,
+// in all graphs. If new is allready stored, the two are merged as by
+// MergeURIs(new, old). It returns ErrNotFound if old is not stored.
+func (tx *Tx) RenameURI(old, new rdf.URI) error {
+	oldID, err := tx.db.getID(tx.tx, old)
+	if err != nil {
+		return err
+	}
+	newID, err := tx.db.getID(tx.tx, new)
+	switch err {
+	case nil:
+		if newID == oldID {
+			return nil
+		}
+		return tx.db.mergeTerms(tx.tx, newID, oldID)
+	case ErrNotFound:
+		// Only the term needs to change, the indices refer to its ID
+		return tx.db.renameTerm(tx.tx, oldID, new)
+	default:
+		return err
+	}
+}
+
+// MergeURIs replaces the URI drop with keep in all the triples where it
+// occurs, in all graphs, and removes drop from the store.
+// It returns ErrNotFound if any of the URIs are not stored.
+func (tx *Tx) MergeURIs(keep, drop rdf.URI) error {
+	keepID, err := tx.db.getID(tx.tx, keep)
+	if err != nil {
+		return err
+	}
+	dropID, err := tx.db.getID(tx.tx, drop)
+	if err != nil {
+		return err
+	}
+	if keepID == dropID {
+		return nil
+	}
+	return tx.db.mergeTerms(tx.tx, keepID, dropID)
+}